@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"log"
 
 	jobs "github.com/evo-cloud/cloudrt/jobs"
 	store "github.com/evo-cloud/cloudrt/jobs/stores/redis"
@@ -63,7 +64,7 @@ func main() {
 
 	// register task executors
 	dispatcher.
-		NewTaskExec("process-job").Entry(createObj).Stage("process", processObj).
+		NewTaskExec("process-obj").Entry(createObj).Stage("process", processObj).
 		NewTaskExec("make-component").Entry(makeComponent).
 		Commit()
 
@@ -73,11 +74,15 @@ func main() {
 		dispatcher.Worker(fmt.Sprintf("worker%d", i))
 	}
 
+	task, err := jobs.NewTask("process-obj").
+		With(&processObjParams{Components: []string{"red", "blue", "green"}}).
+		Build()
+	if err != nil {
+		log.Fatal(err)
+	}
 	dispatcher.NewJob().
 		SetName("simple-job").
-		SetTask(jobs.NewTask("process-obj").
-			With(&processObjParams{Components: []string{"red", "blue", "green"}}).
-			Build()).
+		SetTask(task).
 		Submit()
 
 	dispatcher.Run()
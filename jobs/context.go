@@ -1,12 +1,35 @@
 package jobs
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"golang.org/x/net/context"
+)
 
 // Context provides the context for a running task
 type Context struct {
 	local *localContext
 }
 
+type contextKey int
+
+const (
+	jobIDKey contextKey = iota
+	taskIDKey
+)
+
+// JobIDFrom extracts the job id from the context of a running task
+func JobIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(jobIDKey).(string)
+	return id, ok
+}
+
+// TaskIDFrom extracts the task id from the context of a running task
+func TaskIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(taskIDKey).(string)
+	return id, ok
+}
+
 // Task returns a copy of current task
 func (c Context) Task() Task {
 	return *c.local.taskHandle().Task()
@@ -56,6 +79,15 @@ func (c Context) StopCh() StopChan {
 	return c.local.stopCh
 }
 
+// Context returns a context.Context which is canceled when StopCh
+// is closed: the dispatcher stops, the job is canceled, the lease
+// is lost or the stage deadline passes. It carries the job id and
+// task id, see JobIDFrom and TaskIDFrom. A stage stopped by the
+// dispatcher is re-run later whatever it returns, it's not a failure.
+func (c Context) Context() context.Context {
+	return c.local.ctx
+}
+
 // IsRollback determines if the task is in rollback direction
 func (c Context) IsRollback() bool {
 	return c.Task().Revert
@@ -87,15 +119,25 @@ func (c Context) GetParams(p interface{}) error {
 }
 
 // SetData saves the data of the task
-func (c Context) SetData(p interface{}) (err error) {
+func (c Context) SetData(p interface{}) error {
+	encoded, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
 	t := c.Task()
-	return c.local.taskHandle().Update(t.SetData(p))
+	t.Data = encoded
+	return c.local.taskHandle().Update(&t)
 }
 
 // SetOutput saves the output of the task
-func (c Context) SetOutput(p interface{}) (err error) {
+func (c Context) SetOutput(p interface{}) error {
+	encoded, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
 	t := c.Task()
-	return c.local.taskHandle().Update(t.SetOutput(p))
+	t.Output = encoded
+	return c.local.taskHandle().Update(&t)
 }
 
 // ResumeTo specifies the next stage when sub tasks finish
@@ -105,9 +147,20 @@ func (c Context) ResumeTo(stage string) error {
 	return c.local.taskHandle().Update(&t)
 }
 
-// NewTask starts creating a new sub task
+// NewTask starts creating a new sub task, use SetKey to make
+// the submission idempotent when the stage is re-executed
 func (c Context) NewTask(name string) *TaskBuilder {
-	return &TaskBuilder{Submitter: c, Name: name}
+	t := c.Task()
+	return &TaskBuilder{
+		Submitter:    c,
+		IDGenerator:  c.local.dispatcher().IDGenerator,
+		ParentID:     t.ID,
+		Stage:        t.Stage,
+		Name:         name,
+		Priority:     t.Priority,
+		JobRateLimit: t.JobRateLimit,
+		Tenant:       t.Tenant,
+	}
 }
 
 // Fail creates a task error
@@ -128,9 +181,11 @@ func (c Context) Stuck(err error) *TaskError {
 	return t.NewError(TaskErrStuck).SetMessage("stucked!!").CausedBy(err)
 }
 
-// SubmitTask implements TaskSubmitter
+// SubmitTask implements TaskSubmitter. If the sub task already
+// exists, task is replaced with the existing one.
 func (c Context) SubmitTask(task *Task) error {
 	task.JobID = c.JobID()
 	task.ParentID = c.TaskID()
+	c.local.dispatcher().applyTaskExec(task)
 	return c.local.taskHandle().SubmitTask(task)
 }
@@ -8,8 +8,19 @@ import (
 // Dispatcher submits jobs and executes tasks
 type Dispatcher struct {
 	Strategy          Strategy
+	IDGenerator       IDGenerator
 	Tasks             []*TaskExec
 	HouseKeepInterval time.Duration
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+	JobPollInterval   time.Duration
+	PanicPolicy       PanicPolicy
+	OnStuck           StuckHandler
+	QueueRateLimits   map[string]RateLimit
+	// DrainTimeout bounds the wait for in-flight tasks on Stop, the
+	// tasks not completed in time are handed back to be re-run later.
+	// 0 waits until they complete.
+	DrainTimeout time.Duration
 
 	workers   map[string]*runnerCtl
 	watchers  map[string]*runnerCtl
@@ -18,6 +29,27 @@ type Dispatcher struct {
 	lock      sync.Mutex
 }
 
+// PanicPolicy decides how a panic in task function is handled
+type PanicPolicy int
+
+// Panic policies
+const (
+	PanicFail  PanicPolicy = iota // fail the task and rollback
+	PanicRetry                    // retry the task
+	PanicStuck                    // mark the task stucked
+)
+
+// ErrorType converts the policy to the type of TaskError
+func (p PanicPolicy) ErrorType() TaskErrorType {
+	switch p {
+	case PanicRetry:
+		return TaskErrRetry
+	case PanicStuck:
+		return TaskErrStuck
+	}
+	return TaskErrFail
+}
+
 // StopChan is the chan delivering stop signal
 type StopChan <-chan struct{}
 
@@ -36,8 +68,13 @@ type Watcher interface {
 	Runnable
 }
 
-// HouseKeepInterval is the default setting
-var HouseKeepInterval = time.Second
+// Default settings
+var (
+	HouseKeepInterval = time.Second
+	HeartbeatInterval = time.Second
+	HeartbeatTimeout  = 5 * time.Second
+	JobPollInterval   = 500 * time.Millisecond
+)
 
 type runnerCtl struct {
 	runner Runnable
@@ -60,18 +97,23 @@ func (r *runnerCtl) stop() {
 func NewDispatcher(strategy Strategy) *Dispatcher {
 	return &Dispatcher{
 		Strategy:          strategy,
+		IDGenerator:       DefaultIDGenerator,
 		HouseKeepInterval: HouseKeepInterval,
+		HeartbeatInterval: HeartbeatInterval,
+		HeartbeatTimeout:  HeartbeatTimeout,
+		JobPollInterval:   JobPollInterval,
 	}
 }
 
 // NewJob starts creating a job
 func (d *Dispatcher) NewJob() *JobBuilder {
-	return &JobBuilder{Submitter: d}
+	return &JobBuilder{Submitter: d, IDGenerator: d.IDGenerator}
 }
 
 // SubmitJob implements JobSubmitter
 func (d *Dispatcher) SubmitJob(job *Job) error {
 	// TODO validate job
+	d.applyTaskExec(job.Task)
 	return d.Strategy.SubmitJob(job)
 }
 
@@ -80,13 +122,27 @@ func (d *Dispatcher) AddTaskExecs(execs ...*TaskExec) {
 	d.Tasks = append(d.Tasks, execs...)
 }
 
+// NewTask starts creating a task, e.g. the entry task of a job
+func (d *Dispatcher) NewTask(name string) *TaskBuilder {
+	return &TaskBuilder{IDGenerator: d.IDGenerator, Name: name}
+}
+
 // NewTaskExec defines a new task executor
 func (d *Dispatcher) NewTaskExec(taskName string) *TaskExecBuilder {
 	return &TaskExecBuilder{Dispatcher: d, Executor: TaskExec{Name: taskName}}
 }
 
-// Worker creates a worker
-func (d *Dispatcher) Worker(id string) Worker {
+// Worker creates a worker executing one task at a time
+func (d *Dispatcher) Worker(id string, queues ...string) Worker {
+	return d.ConcurrentWorker(id, 1, queues...)
+}
+
+// ConcurrentWorker creates a worker executing up to concurrency
+// tasks in parallel
+func (d *Dispatcher) ConcurrentWorker(id string, concurrency int, queues ...string) Worker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
 	d.lock.Lock()
 	defer d.lock.Unlock()
 	if d.workers == nil {
@@ -96,7 +152,13 @@ func (d *Dispatcher) Worker(id string) Worker {
 	if rctl == nil {
 		rctl = newRunnerCtl(&localWorker{
 			dispatcher: d,
-			strategy:   d.Strategy.NewWorker(id),
+			strategy: d.Strategy.NewWorker(id, WorkerOptions{
+				Queues:         queues,
+				Accept:         d.hasTaskExec,
+				MaxConcurrency: d.maxConcurrency,
+				RateLimits:     d.rateLimits,
+			}),
+			concurrency: concurrency,
 		})
 		d.workers[id] = rctl
 	}
@@ -148,7 +210,9 @@ func (d *Dispatcher) Start() *Dispatcher {
 	return d
 }
 
-// Stop notifies workers and background tasks to exit
+// Stop notifies workers and background tasks to exit, workers stop
+// fetching and exit after in-flight tasks complete, or DrainTimeout
+// passes
 func (d *Dispatcher) Stop() *Dispatcher {
 	d.lock.Lock()
 	for _, rctl := range d.runners {
@@ -171,6 +235,18 @@ func (d *Dispatcher) Run() *Dispatcher {
 	return d
 }
 
+// CancelJob requests cancellation of the job: tasks not started are
+// aborted, running stages are stopped, and completed tasks are
+// reverted.
+func (d *Dispatcher) CancelJob(id string) error {
+	return d.Strategy.CancelJob(id)
+}
+
+func (d *Dispatcher) isJobCanceling(id string) bool {
+	canceling, err := d.Strategy.IsJobCanceling(id)
+	return err == nil && canceling
+}
+
 // Task queries task by id
 func (d *Dispatcher) Task(id string) (Task, error) {
 	task, err := d.Strategy.QueryTask(id)
@@ -195,6 +271,85 @@ func (d *Dispatcher) Job(id string) (Job, error) {
 	return *job, nil
 }
 
+// hasTaskExec determines if the task can be executed locally
+func (d *Dispatcher) hasTaskExec(task *Task) bool {
+	return d.findTaskExec(task.Name) != nil
+}
+
+func (d *Dispatcher) maxConcurrency(name string) int {
+	if exec := d.findTaskExec(name); exec != nil {
+		return exec.MaxConcurrency
+	}
+	return 0
+}
+
+// LimitQueueRate limits the starts per second of the tasks in the queue
+func (d *Dispatcher) LimitQueueRate(queue string, rate float64, burst int) *Dispatcher {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.QueueRateLimits == nil {
+		d.QueueRateLimits = make(map[string]RateLimit)
+	}
+	d.QueueRateLimits[queue] = RateLimit{Rate: rate, Burst: burst}
+	return d
+}
+
+// rateLimits collects the rate limits of the task name, the queue
+// and the job
+func (d *Dispatcher) rateLimits(task *Task) map[string]RateLimit {
+	limits := make(map[string]RateLimit)
+	if exec := d.findTaskExec(task.Name); exec != nil && exec.RateLimit != nil {
+		limits["task/"+task.Name] = *exec.RateLimit
+	}
+	d.lock.Lock()
+	if limit, ok := d.QueueRateLimits[task.Queue]; ok {
+		limits["queue/"+task.Queue] = limit
+	}
+	d.lock.Unlock()
+	if task.JobRateLimit != nil {
+		limits["job/"+task.JobID] = *task.JobRateLimit
+	}
+	return limits
+}
+
+// applyTaskExec applies the defaults from TaskExec before submission
+func (d *Dispatcher) applyTaskExec(task *Task) {
+	if exec := d.findTaskExec(task.Name); exec != nil && task.Queue == "" {
+		task.Queue = exec.Queue
+	}
+}
+
+func (d *Dispatcher) findTaskExec(name string) *TaskExec {
+	for _, t := range d.Tasks {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// retryPolicy returns the retry policy of the current direction,
+// a failed rollback is not retried by default
+func (d *Dispatcher) retryPolicy(task *Task) *RetryPolicy {
+	exec := d.findTaskExec(task.Name)
+	if task.Revert {
+		if task.RevertPolicy != nil {
+			return task.RevertPolicy
+		}
+		if exec != nil && exec.RevertPolicy != nil {
+			return exec.RevertPolicy
+		}
+		return NoBackoff(0)
+	}
+	if task.RetryPolicy != nil {
+		return task.RetryPolicy
+	}
+	if exec != nil && exec.RetryPolicy != nil {
+		return exec.RetryPolicy
+	}
+	return NoBackoff(task.MaxRetries)
+}
+
 func (d *Dispatcher) findStage(name, stage string) *Stage {
 	for _, t := range d.Tasks {
 		if t.Name != name || len(t.Stages) == 0 {
@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -9,6 +10,12 @@ import (
 // Common errors
 var (
 	ErrTaskNonRevertable = errors.New("task is not revertable")
+	ErrTaskBusy          = errors.New("task is owned by others")
+	ErrTaskExpired       = errors.New("task deadline exceeded")
+	ErrTaskLeaseLost     = errors.New("task lease lost")
+	ErrTaskIDConflict    = errors.New("task id is used by another task")
+	ErrTaskNotStucked    = errors.New("task is not stucked")
+	ErrConflict          = errors.New("modified concurrently")
 )
 
 // NotExistError indicates object doesn't exist
@@ -41,6 +48,7 @@ const (
 	TaskErrFail
 	TaskErrRetry
 	TaskErrStuck
+	TaskErrTimeout
 )
 
 // TaskError is the type for error when task failed
@@ -80,6 +88,37 @@ func (e *TaskError) CausedBy(err error) *TaskError {
 	return e
 }
 
+// encodedTaskError is the persisted form of TaskError,
+// the cause is saved as message as error is not serializable
+type encodedTaskError struct {
+	*taskErrorFields
+	Cause string `json:"cause,omitempty"`
+}
+
+type taskErrorFields TaskError
+
+// MarshalJSON implements json.Marshaler
+func (e TaskError) MarshalJSON() ([]byte, error) {
+	fields := taskErrorFields(e)
+	encoded := encodedTaskError{taskErrorFields: &fields}
+	if e.Cause != nil {
+		encoded.Cause = e.Cause.Error()
+	}
+	return json.Marshal(&encoded)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (e *TaskError) UnmarshalJSON(data []byte) error {
+	encoded := encodedTaskError{taskErrorFields: (*taskErrorFields)(e)}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	if encoded.Cause != "" {
+		e.Cause = errors.New(encoded.Cause)
+	}
+	return nil
+}
+
 // Error implements error
 func (e *TaskError) Error() string {
 	msg := fmt.Sprintf("Task[%s]: %d: %s @%s",
@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	crand "crypto/rand"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// IDGenerator generates globally unique ids
+type IDGenerator interface {
+	NewID() string
+}
+
+// IDGeneratorFunc adapts a function to IDGenerator
+type IDGeneratorFunc func() string
+
+// NewID implements IDGenerator
+func (f IDGeneratorFunc) NewID() string {
+	return f()
+}
+
+// DefaultIDGenerator generates ids when not specified
+var DefaultIDGenerator IDGenerator = ULIDGenerator{}
+
+// ULIDGenerator generates ULIDs: 48-bit timestamp in milliseconds
+// followed by 80 random bits, encoded as 26 chars in Crockford's
+// base32. The ids sort by creation time, and the random part
+// spreads them evenly across partitions.
+type ULIDGenerator struct{}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewID implements IDGenerator
+func (ULIDGenerator) NewID() string {
+	var id [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	for i := 5; i >= 0; i-- {
+		id[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := crand.Read(id[6:]); err != nil {
+		for i := 6; i < len(id); i++ {
+			id[i] = byte(rand.Intn(256))
+		}
+	}
+
+	// 26 chars encode 130 bits, the leading 2 bits are zero
+	encoded := make([]byte, 26)
+	for i := range encoded {
+		var v byte
+		for j := 0; j < 5; j++ {
+			v <<= 1
+			bit := i*5 + j - 2
+			if bit >= 0 && id[bit/8]&(0x80>>uint(bit%8)) != 0 {
+				v |= 1
+			}
+		}
+		encoded[i] = crockfordBase32[v]
+	}
+	return string(encoded)
+}
+
+// DeriveID derives a deterministic id from the parent id, the stage
+// and the key. The stage and the key are escaped, so the last two
+// ":" separated parts always tell them and different inputs never
+// derive the same id.
+func DeriveID(parentID, stage, key string) string {
+	return parentID + ":" + escapeIDPart(stage) + ":" + escapeIDPart(key)
+}
+
+var idPartEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+func escapeIDPart(part string) string {
+	return idPartEscaper.Replace(part)
+}
+
+func newID(gen IDGenerator) string {
+	if gen == nil {
+		gen = DefaultIDGenerator
+	}
+	return gen.NewID()
+}
@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeriveID(t *testing.T) {
+	ids := map[string][3]string{}
+	for _, parts := range [][3]string{
+		{"root", "", "process:x"},
+		{"root", "process", "x"},
+		{"root", "process:x", ""},
+		{"root:process", "x", ""},
+		{"root", "", "process%3Ax"},
+		{"root", "", ""},
+	} {
+		id := DeriveID(parts[0], parts[1], parts[2])
+		if prev, ok := ids[id]; ok {
+			t.Fatalf("%v and %v both derive %q", prev, parts, id)
+		}
+		ids[id] = parts
+	}
+	if id := DeriveID("root", "s2", "b"); id != "root:s2:b" {
+		t.Fatalf("unexpected id %q", id)
+	}
+}
+
+func TestULIDOrder(t *testing.T) {
+	gen := ULIDGenerator{}
+	partitions := make(map[int]bool)
+	var prev []string
+	for round := 0; round < 5; round++ {
+		var ids []string
+		for i := 0; i < 20; i++ {
+			id := gen.NewID()
+			if len(id) != 26 {
+				t.Fatalf("unexpected id %q", id)
+			}
+			partitions[Partition(id)] = true
+			ids = append(ids, id)
+		}
+		// ids of the same millisecond are not ordered
+		for _, p := range prev {
+			for _, id := range ids {
+				if id <= p {
+					t.Fatalf("id %s created after %s sorts before it", id, p)
+				}
+			}
+		}
+		prev = ids
+		time.Sleep(2 * time.Millisecond)
+	}
+	if len(partitions) < 10 {
+		t.Fatalf("ids fall in %d partitions only", len(partitions))
+	}
+}
@@ -1,14 +1,124 @@
 package jobs
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// JobState is the state of a job, derived from the entry task
+type JobState int
+
+// Job states
+const (
+	JobPending   JobState = iota // entry task not started yet
+	JobRunning                   // entry task is running or rolling back
+	JobSucceeded                 // entry task completed successfully
+	JobFailed                    // entry task failed, rolled back or not
+	JobAborted                   // entry task aborted without failures
+	JobStuck                     // entry task is stucked
+	JobCancelled                 // entry task rolled back due to cancellation
+)
+
+// IsFinal determines if the job will not change state any more
+// without intervention
+func (s JobState) IsFinal() bool {
+	return s >= JobSucceeded
+}
+
+// JobStateOf derives job state from the entry task
+func JobStateOf(task *Task, canceling bool) JobState {
+	switch task.State {
+	case TaskCreated, TaskPending:
+		if !task.Started() {
+			return JobPending
+		}
+	case TaskStucked:
+		return JobStuck
+	case TaskCompleted:
+		switch task.Result {
+		case TaskSuccess:
+			return JobSucceeded
+		case TaskAborted:
+			if canceling {
+				return JobCancelled
+			}
+			// the result of a rollback is always aborted,
+			// the failure is told by the errors causing it
+			if task.Revert && hasFailed(task) {
+				return JobFailed
+			}
+			return JobAborted
+		default:
+			return JobFailed
+		}
+	}
+	return JobRunning
+}
+
+// hasFailed determines if the task ever failed
+func hasFailed(task *Task) bool {
+	for _, err := range task.Errors {
+		if err.Type != TaskErrIgnored {
+			return true
+		}
+	}
+	return false
+}
+
+// JobProgress counts the tasks in the task tree of a job
+type JobProgress struct {
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Running   int `json:"running"`
+	Waiting   int `json:"waiting"`
+	Stucked   int `json:"stucked"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Aborted   int `json:"aborted"`
+}
+
+// Count adds the task into progress
+func (p *JobProgress) Count(task *Task) {
+	p.Total++
+	switch task.State {
+	case TaskCreated, TaskPending:
+		p.Pending++
+	case TaskRunning:
+		p.Running++
+	case TaskWaiting:
+		p.Waiting++
+	case TaskStucked:
+		p.Stucked++
+	case TaskCompleted:
+		switch task.Result {
+		case TaskSuccess:
+			p.Succeeded++
+		case TaskAborted:
+			p.Aborted++
+		default:
+			p.Failed++
+		}
+	}
+}
 
 // Job defines the details of a job
 type Job struct {
-	ID        string    `json:"id"`         // globally unique job id
-	Name      string    `json:"name"`       // job name, optionally
-	Task      *Task     `json:"task"`       // the entry task
-	CreatedAt time.Time `json:"created-at"` // job creation time
-	UpdatedAt time.Time `json:"updated-at"` // last updated time
+	ID        string          `json:"id"`                 // globally unique job id
+	Name      string          `json:"name"`               // job name, optionally
+	Task      *Task           `json:"task"`               // the entry task
+	State     JobState        `json:"state"`              // derived from the entry task
+	Output    json.RawMessage `json:"output"`             // output of the entry task
+	Progress  *JobProgress    `json:"progress,omitempty"` // task counts of the task tree
+	CreatedAt time.Time       `json:"created-at"`         // job creation time
+	UpdatedAt time.Time       `json:"updated-at"`         // last updated time
+}
+
+// GetOutput extracts the output of the job
+func (j *Job) GetOutput(p interface{}) error {
+	if j.Output == nil {
+		return nil
+	}
+	return json.Unmarshal(j.Output, p)
 }
 
 // JobSubmitter defines the contract which submits a job
@@ -18,10 +128,15 @@ type JobSubmitter interface {
 
 // JobBuilder is the helper creates a job
 type JobBuilder struct {
-	Submitter JobSubmitter
-	ID        string
-	Name      string
-	Task      *Task
+	Submitter   JobSubmitter
+	IDGenerator IDGenerator
+	ID          string
+	Name        string
+	Task        *Task
+	Priority    int
+	RateLimit   *RateLimit
+	Tenant      string
+	ScheduledAt time.Time
 }
 
 // SetID specifies the globally unique job id
@@ -42,6 +157,37 @@ func (b *JobBuilder) SetTask(task *Task) *JobBuilder {
 	return b
 }
 
+// SetPriority specifies the priority of the entry task, which is
+// inherited by sub tasks by default
+func (b *JobBuilder) SetPriority(priority int) *JobBuilder {
+	b.Priority = priority
+	return b
+}
+
+// LimitRate limits the starts per second of all tasks in the job
+func (b *JobBuilder) LimitRate(rate float64, burst int) *JobBuilder {
+	b.RateLimit = &RateLimit{Rate: rate, Burst: burst}
+	return b
+}
+
+// SetTenant specifies the tenant owning the job, with fair scheduling
+// the jobs of a tenant share the workers with other tenants as a whole
+func (b *JobBuilder) SetTenant(tenant string) *JobBuilder {
+	b.Tenant = tenant
+	return b
+}
+
+// RunAt specifies the time when the job starts
+func (b *JobBuilder) RunAt(t time.Time) *JobBuilder {
+	b.ScheduledAt = t
+	return b
+}
+
+// Delay specifies the job starts after a duration
+func (b *JobBuilder) Delay(d time.Duration) *JobBuilder {
+	return b.RunAt(time.Now().Add(d))
+}
+
 // Submit submits the job for execution
 func (b *JobBuilder) Submit() (*Job, error) {
 	job := &Job{
@@ -50,9 +196,27 @@ func (b *JobBuilder) Submit() (*Job, error) {
 		Task: b.Task,
 	}
 	if job.ID == "" {
-		// TODO generate ID
+		job.ID = newID(b.IDGenerator)
+	}
+	if job.Task.ID == "" {
+		job.Task.ID = newID(b.IDGenerator)
 	}
 	job.Task.JobID = job.ID
+	if b.Priority != 0 {
+		job.Task.Priority = b.Priority
+	}
+	if b.RateLimit != nil {
+		job.Task.JobRateLimit = b.RateLimit
+	}
+	if b.Tenant != "" {
+		job.Task.Tenant = b.Tenant
+	}
+	if !b.ScheduledAt.IsZero() {
+		if job.Task.Stats == nil {
+			job.Task.Stats = &TaskStats{}
+		}
+		job.Task.Stats.ScheduledAt = b.ScheduledAt
+	}
 	job.Task.CreatedAt = time.Now()
 	job.Task.UpdatedAt = job.Task.CreatedAt
 	job.CreatedAt = job.Task.CreatedAt
@@ -0,0 +1,97 @@
+package jobs
+
+import "encoding/json"
+
+// StuckHandler is called when a task becomes stucked, e.g. to alert
+// the operators. The task carries the full history of errors.
+type StuckHandler func(task Task)
+
+// saveTask updates the task and escalates if it becomes stucked
+func (d *Dispatcher) saveTask(handle TaskHandle, task *Task) error {
+	if err := handle.Update(task); err != nil {
+		return err
+	}
+	if task.State == TaskStucked && d.OnStuck != nil {
+		d.OnStuck(*task)
+	}
+	return nil
+}
+
+// StuckTasks lists the stucked tasks
+func (d *Dispatcher) StuckTasks() ([]Task, error) {
+	found, err := d.Strategy.QueryStuckTasks()
+	if err != nil {
+		return nil, err
+	}
+	tasks := make([]Task, 0, len(found))
+	for _, task := range found {
+		tasks = append(tasks, *task)
+	}
+	return tasks, nil
+}
+
+// RetryTask resumes a stucked task with the retries of current
+// direction reset. A forward task resumes to the stage stucked,
+// and rollback starts over.
+func (d *Dispatcher) RetryTask(id string) error {
+	return d.resolveStuckTask(id, func(task *Task) {
+		task.State = TaskPending
+		task.Result = TaskUnknown
+		if task.Revert {
+			task.RevertRetries = 0
+		} else {
+			task.Retries = 0
+			if task.ResumeTo == "" {
+				task.ResumeTo = task.Stage
+			}
+		}
+	})
+}
+
+// SkipTask completes a stucked task as aborted. In forward direction,
+// the parent fails and compensates the other sub tasks.
+func (d *Dispatcher) SkipTask(id string) error {
+	return d.resolveStuckTask(id, func(task *Task) {
+		task.State = TaskCompleted
+		task.Result = TaskAborted
+	})
+}
+
+// CompleteTask forces a stucked task to complete with the output,
+// the output is kept if nil. In forward direction, the task completes
+// successfully, otherwise, the rollback is considered done.
+func (d *Dispatcher) CompleteTask(id string, output interface{}) error {
+	var encoded []byte
+	if output != nil {
+		var err error
+		if encoded, err = json.Marshal(output); err != nil {
+			return err
+		}
+	}
+	return d.resolveStuckTask(id, func(task *Task) {
+		task.State = TaskCompleted
+		if task.Revert {
+			task.Result = TaskAborted
+		} else {
+			task.Result = TaskSuccess
+		}
+		if encoded != nil {
+			task.Output = encoded
+		}
+	})
+}
+
+func (d *Dispatcher) resolveStuckTask(id string, fn func(*Task)) error {
+	operator := d.Strategy.NewWorker("operator:"+newID(d.IDGenerator), WorkerOptions{})
+	handle, err := operator.AcquireTask(id)
+	if err != nil {
+		return err
+	}
+	defer handle.Done()
+	task := handle.Task()
+	if task.State != TaskStucked {
+		return ErrTaskNotStucked
+	}
+	fn(task)
+	return handle.Update(task)
+}
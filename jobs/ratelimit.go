@@ -0,0 +1,60 @@
+package jobs
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimit limits the number of task starts per second
+type RateLimit struct {
+	Rate  float64 `json:"rate"`  // tokens refilled per second, unlimited if 0
+	Burst int     `json:"burst"` // capacity of the bucket, at least 1
+}
+
+// Capacity is the max number of tokens in the bucket
+func (l RateLimit) Capacity() float64 {
+	if l.Burst < 1 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+// RefillTime is the time an empty bucket becomes full
+func (l RateLimit) RefillTime() time.Duration {
+	return time.Duration(l.Capacity() / l.Rate * float64(time.Second))
+}
+
+// TokenBucketState is the persisted state of a token bucket,
+// the zero value is a full bucket
+type TokenBucketState struct {
+	Tokens float64   `json:"tokens"`
+	At     time.Time `json:"at"`
+}
+
+// Take refills the bucket to now and takes one token if available
+func (s *TokenBucketState) Take(limit RateLimit, now time.Time) bool {
+	s.refill(limit, now)
+	if s.Tokens < 1 {
+		return false
+	}
+	s.Tokens--
+	return true
+}
+
+// Return refills the bucket to now and puts back one token
+func (s *TokenBucketState) Return(limit RateLimit, now time.Time) {
+	s.refill(limit, now)
+	s.Tokens = math.Min(limit.Capacity(), s.Tokens+1)
+}
+
+func (s *TokenBucketState) refill(limit RateLimit, now time.Time) {
+	capacity := limit.Capacity()
+	if s.At.IsZero() {
+		s.Tokens = capacity
+	} else if elapsed := now.Sub(s.At); elapsed > 0 {
+		s.Tokens = math.Min(capacity, s.Tokens+elapsed.Seconds()*limit.Rate)
+	}
+	if now.After(s.At) {
+		s.At = now
+	}
+}
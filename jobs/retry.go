@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy defines the retry budget and the backoff between retries.
+// It's persisted with the task, so it's plain data.
+type RetryPolicy struct {
+	MaxRetries uint          `json:"max-retries"` // max count of retries
+	Delay      time.Duration `json:"delay"`       // backoff of first retry
+	MaxDelay   time.Duration `json:"max-delay"`   // cap of backoff, 0 for no cap
+	Multiplier float64       `json:"multiplier"`  // backoff growth, <= 1 for fixed
+	Jitter     float64       `json:"jitter"`      // randomly reduce backoff by up to the fraction
+}
+
+// NoBackoff retries immediately
+func NoBackoff(maxRetries uint) *RetryPolicy {
+	return &RetryPolicy{MaxRetries: maxRetries}
+}
+
+// FixedBackoff retries after a fixed delay
+func FixedBackoff(maxRetries uint, delay time.Duration) *RetryPolicy {
+	return &RetryPolicy{MaxRetries: maxRetries, Delay: delay}
+}
+
+// ExponentialBackoff multiplies the delay on each retry
+func ExponentialBackoff(maxRetries uint, delay time.Duration, multiplier float64) *RetryPolicy {
+	return &RetryPolicy{MaxRetries: maxRetries, Delay: delay, Multiplier: multiplier}
+}
+
+// WithMaxDelay caps the backoff
+func (p *RetryPolicy) WithMaxDelay(d time.Duration) *RetryPolicy {
+	p.MaxDelay = d
+	return p
+}
+
+// WithJitter randomly reduces the backoff by up to the fraction
+func (p *RetryPolicy) WithJitter(fraction float64) *RetryPolicy {
+	p.Jitter = fraction
+	return p
+}
+
+// maxBackoff caps the backoff without MaxDelay, far from overflowing
+// time.Duration
+const maxBackoff = 365 * 24 * time.Hour
+
+// Backoff calculates the delay before the retry, which starts from 1
+func (p *RetryPolicy) Backoff(retry uint) time.Duration {
+	if p.Delay <= 0 {
+		return 0
+	}
+	delay := float64(p.Delay)
+	if p.Multiplier > 1 && retry > 1 {
+		delay *= math.Pow(p.Multiplier, float64(retry-1))
+	}
+	limit := maxBackoff
+	if p.MaxDelay > 0 {
+		limit = p.MaxDelay
+	}
+	if delay > float64(limit) {
+		delay = float64(limit)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 - math.Min(p.Jitter, 1)*rand.Float64()
+	}
+	return time.Duration(delay)
+}
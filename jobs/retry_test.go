@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := ExponentialBackoff(10, time.Second, 2)
+	for retry, expected := range map[uint]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		4: 8 * time.Second,
+	} {
+		if delay := p.Backoff(retry); delay != expected {
+			t.Fatalf("retry %d: expect %v, got %v", retry, expected, delay)
+		}
+	}
+	if delay := p.WithMaxDelay(5 * time.Second).Backoff(4); delay != 5*time.Second {
+		t.Fatalf("expect capped, got %v", delay)
+	}
+	if delay := NoBackoff(10).Backoff(3); delay != 0 {
+		t.Fatalf("expect no backoff, got %v", delay)
+	}
+}
+
+func TestBackoffOverflow(t *testing.T) {
+	p := ExponentialBackoff(1000, time.Second, 10)
+	for _, retry := range []uint{20, 100, 1000} {
+		if delay := p.Backoff(retry); delay != maxBackoff {
+			t.Fatalf("retry %d: expect %v, got %v", retry, maxBackoff, delay)
+		}
+	}
+	p.WithMaxDelay(time.Hour).WithJitter(0.5)
+	if delay := p.Backoff(1000); delay < 30*time.Minute || delay > time.Hour {
+		t.Fatalf("expect capped by max delay, got %v", delay)
+	}
+}
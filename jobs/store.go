@@ -64,14 +64,72 @@ type OrderedList interface {
 	KeySet
 }
 
+// TimeIndex is a set of keys ordered by the time associated
+type TimeIndex interface {
+	// Set adds the key or updates the time of existing key
+	Set(id string, at time.Time) error
+	// Remove removes the key
+	Remove(id string) error
+	// Due enumerates the keys with time not after the specified one
+	// in the order of time
+	Due(at time.Time, opts EnumOptions) Enumerator
+}
+
+// TokenBucket is a rate limiter shared by all clients of the store
+type TokenBucket interface {
+	// Take takes a token atomically, false if the bucket is empty
+	Take(limit RateLimit) (bool, error)
+	// Return puts back a token taken but not used
+	Return(limit RateLimit) error
+}
+
+// Notifier is optionally implemented by Store to push notifications,
+// so clients are woken up instead of polling
+type Notifier interface {
+	// Notify wakes up the subscribers of the channel
+	Notify(channel string) error
+	// Subscribe delivers the notifications of the channel until stopCh
+	// is closed, notifications may be coalesced. The returned chan is
+	// closed when the subscription ends, including on failures.
+	Subscribe(channel string, stopCh StopChan) (<-chan struct{}, error)
+}
+
+// Batch collects writes which are applied all-or-nothing by Commit
+type Batch interface {
+	// Expect fails the commit unless the key in the bucket still has
+	// the value read before, nil expects the key not exist
+	Expect(bucket, key string, val Value)
+	// Put puts the value in the bucket
+	Put(bucket, key string, value interface{}, ttl time.Duration)
+	// SetKey adds or removes the key in the ordered list
+	SetKey(list, id string, exist bool)
+	// SetTime adds the key to the time index or updates the time
+	SetTime(index, id string, at time.Time)
+	// RemoveTime removes the key from the time index
+	RemoveTime(index, id string)
+	// Commit applies the writes if all expectations hold,
+	// otherwise nothing is written and ErrConflict is returned
+	Commit() error
+}
+
+// Transactional is optionally implemented by Store to apply
+// writes across buckets, lists and indices atomically
+type Transactional interface {
+	Batch() Batch
+}
+
 // Store is the persistent storage for jobs/tasks
 type Store interface {
 	// Bucket obtains a reference to a partitioned store
 	Bucket(name string) PartitionedStore
 	// OrderedList obtains a handle to an ordered list
 	OrderedList(name string) OrderedList
+	// TimeIndex obtains a handle to a time index
+	TimeIndex(name string) TimeIndex
 	// Acquire acquires a lock
 	Acquire(name, ownerID string) (Acquisition, error)
+	// TokenBucket obtains a handle to a token bucket
+	TokenBucket(name string) TokenBucket
 }
 
 const (
@@ -1,9 +1,8 @@
 package etcd
 
 import (
+	"fmt"
 	etcd "github.com/coreos/etcd/client"
-	"golang.org/x/net/context"
-	"log"
 	"time"
 )
 
@@ -28,70 +27,80 @@ func (a *acquisition) TTL() time.Duration {
 }
 
 func (a *acquisition) Refresh(ttl time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	api := etcd.NewKeysAPI(*a.s.Client)
-
-	key := a.key()
+	ctx, cancel := requestContext()
 	setOp := etcd.SetOptions{
 		PrevValue: a.owner,
-		TTL:       ttl,
-		Refresh:   true,
+		TTL:       ttlOption(ttl),
 	}
-
-	_, err := api.Set(ctx, key, a.owner, &setOp)
+	_, err := a.s.keysAPI().Set(ctx, a.key(), a.owner, &setOp)
 	cancel()
 
 	if err != nil {
-		log.Fatal(err)
+		return a.ownershipError(err)
 	}
-
-	return err
+	a.ttl = ttl
+	return nil
 }
 
 func (a *acquisition) Release() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	api := etcd.NewKeysAPI(*a.s.Client)
-	key := a.key()
-
+	ctx, cancel := requestContext()
 	delOp := etcd.DeleteOptions{
 		PrevValue: a.owner,
 	}
-
-	_, err := api.Delete(ctx, key, &delOp)
+	_, err := a.s.keysAPI().Delete(ctx, a.key(), &delOp)
 	cancel()
 
 	if err != nil {
-		log.Fatal(err)
+		return a.ownershipError(err)
 	}
-
-	return err
+	return nil
 }
 
 func (a *acquisition) tryAcquire() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	api := etcd.NewKeysAPI(*a.s.Client)
-	key := a.key()
-
+	api := a.s.keysAPI()
+	ctx, cancel := requestContext()
 	setOp := etcd.SetOptions{
-		PrevExist: "PrevNoExist",
+		PrevExist: etcd.PrevNoExist,
+		TTL:       ttlOption(a.ttl),
 	}
-
-	resp, err := api.Set(ctx, key, a.owner, &setOp)
+	_, err := api.Set(ctx, a.key(), a.owner, &setOp)
 	cancel()
 
-	if err != nil {
-		log.Fatal(err)
+	if err == nil {
+		a.ownedBy = a.owner
+		return nil
+	} else if !isErrorCode(err, etcd.ErrorCodeNodeExist) {
+		return err
 	}
 
-	respNode := resp.Node
-	if !respNode.Dir {
-		a.ownedBy = respNode.Value
-		a.ttl = time.Duration(respNode.TTL) * time.Second
+	ctx, cancel = requestContext()
+	getOp := etcd.GetOptions{
+		Quorum: true,
 	}
+	resp, err := api.Get(ctx, a.key(), &getOp)
+	cancel()
 
-	return err
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		// released in between, leave it to next attempt
+		return nil
+	} else if err != nil {
+		return err
+	}
+	a.ownedBy = resp.Node.Value
+	if a.ownedBy == a.owner {
+		return a.Refresh(a.ttl)
+	}
+	return nil
 }
 
 func (a *acquisition) key() string {
-	return a.name + "/" + a.name
+	return a.name
+}
+
+func (a *acquisition) ownershipError(err error) error {
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) ||
+		isErrorCode(err, etcd.ErrorCodeTestFailed) {
+		return fmt.Errorf("not owned by %s", a.owner)
+	}
+	return err
 }
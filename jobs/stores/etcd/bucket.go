@@ -4,8 +4,6 @@ import (
 	"encoding/json"
 	etcd "github.com/coreos/etcd/client"
 	"github.com/evo-cloud/cloudrt/jobs"
-	"golang.org/x/net/context"
-	"log"
 	"strconv"
 	"time"
 )
@@ -16,19 +14,22 @@ type bucket struct {
 }
 
 type bucketEnum struct {
-	name   string
-	cursor int
-	count  int
-	store  *Store
-	end    bool
+	name    string
+	lastKey string
+	count   int
+	store   *Store
+	end     bool
 }
 
 func (b *bucket) Enumerate(opts jobs.EnumOptions) jobs.Enumerator {
+	count := opts.PageSize
+	if count <= 0 {
+		count = 10
+	}
 	return &bucketEnum{
-		name:   b.prefix + strconv.Itoa(opts.Partition),
-		cursor: 0,
-		count:  opts.PageSize,
-		store:  b.s,
+		name:  b.prefix + strconv.Itoa(opts.Partition),
+		count: count,
+		store: b.s,
 	}
 }
 
@@ -37,140 +38,115 @@ func (e *bucketEnum) Next() ([]jobs.Value, error) {
 		return nil, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	api := etcd.NewKeysAPI(*e.store.Client)
+	ctx, cancel := requestContext()
 	getOp := etcd.GetOptions{
 		Sort:   true,
 		Quorum: true,
 	}
-
-	resp, err := api.Get(ctx, e.name, &getOp)
+	resp, err := e.store.keysAPI().Get(ctx, e.name, &getOp)
 	cancel()
 
-	if err != nil {
-		log.Fatal(err)
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		e.end = true
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
 
-	respNode := resp.Node
-	if respNode.Dir {
-		nodes := respNode.Nodes
-		vals := make([]jobs.Value, 0, len(nodes))
-		counter := 0
-
-		// capture the slice defined by cursor and pageSize
-		for index, node := range nodes {
-			encoded, _ := json.Marshal(node.Value)
-
-			if (index >= e.cursor) && (counter < e.count) {
-				vals = append(vals, &value{
-					data: string(encoded),
-					ttl:  jobs.NoTTL,
-				})
-				counter++
-			} else if counter >= e.count {
-				break
-			} else if ((index + 1) == len(nodes)) && (counter <= e.count) {
-				e.end = true
-			}
+	// etcd v2 has no cursor, the whole partition is fetched
+	// and the page starts after the last returned key
+	vals := make([]jobs.Value, 0, e.count)
+	for _, node := range resp.Node.Nodes {
+		if node.Dir || node.Key <= e.lastKey {
+			continue
 		}
-
-		if e.end {
-			e.cursor = 0
+		if len(vals) >= e.count {
+			return vals, nil
 		}
-
-		if counter < e.count {
-			e.cursor = 0
-		} else {
-			e.cursor = e.cursor + e.count
-		}
-
-		return vals, nil
+		vals = append(vals, nodeValue(node))
+		e.lastKey = node.Key
 	}
-
-	return nil, nil
+	e.end = true
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	return vals, nil
 }
 
 func (b *bucket) Put(key string, value interface{}, ttl time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	api := etcd.NewKeysAPI(*b.s.Client)
-
 	encoded, err := json.Marshal(value)
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
-	key = b.mapKey(key)
+
+	ctx, cancel := requestContext()
 	setOp := etcd.SetOptions{
-		TTL:     ttl,
-		Refresh: true,
+		TTL: ttlOption(ttl),
 	}
-	_, err = api.Set(ctx, key, string(encoded), &setOp)
+	_, err = b.s.keysAPI().Set(ctx, b.mapKey(key), string(encoded), &setOp)
 	cancel()
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	return err
 }
 
-func (b *bucket) Get(key string) (val jobs.Value, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	api := etcd.NewKeysAPI(*b.s.Client)
-	key = b.mapKey(key)
-	getOp := etcd.GetOptions{
-		Quorum: true,
+func (b *bucket) Get(key string) (jobs.Value, error) {
+	node, err := b.get(b.mapKey(key))
+	if node == nil || err != nil {
+		return nil, err
 	}
+	return nodeValue(node), nil
+}
 
-	resp, err := api.Get(ctx, key, &getOp)
-	cancel()
-	if err != nil {
-		log.Fatal(err)
-	}
+func (b *bucket) Expire(key string, ttl time.Duration) error {
+	key = b.mapKey(key)
+	for {
+		node, err := b.get(key)
+		if node == nil || err != nil {
+			return err
+		}
 
-	node := resp.Node
+		// etcd v2 only changes TTL by setting the value again,
+		// compare-and-swap to not overwrite concurrent updates
+		ctx, cancel := requestContext()
+		setOp := etcd.SetOptions{
+			PrevIndex: node.ModifiedIndex,
+			TTL:       ttlOption(ttl),
+		}
+		_, err = b.s.keysAPI().Set(ctx, key, node.Value, &setOp)
+		cancel()
 
-	if !node.Dir {
-		encoded, _ := json.Marshal(node.Value)
-		val = &value{
-			data: string(encoded),
-			ttl:  time.Duration(node.TTL) * time.Second,
+		if !isErrorCode(err, etcd.ErrorCodeTestFailed) {
+			return err
 		}
 	}
-
-	return nil, err
-}
-
-func (b *bucket) Expire(key string, ttl time.Duration) (err error) {
-	log.Println("Expire method not supported in bucket")
-	return nil
 }
 
-func (b *bucket) Remove(key string) (val jobs.Value, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	api := etcd.NewKeysAPI(*b.s.Client)
-	key = b.mapKey(key)
+func (b *bucket) Remove(key string) (jobs.Value, error) {
+	ctx, cancel := requestContext()
+	resp, err := b.s.keysAPI().Delete(ctx, b.mapKey(key), nil)
+	cancel()
 
-	delOp := etcd.DeleteOptions{
-		PrevValue: "",
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
+	return nodeValue(resp.PrevNode), nil
+}
 
-	resp, err := api.Delete(ctx, key, &delOp)
-	cancel()
-	if err != nil {
-		log.Fatal(err)
+func (b *bucket) get(key string) (*etcd.Node, error) {
+	ctx, cancel := requestContext()
+	getOp := etcd.GetOptions{
+		Quorum: true,
 	}
+	resp, err := b.s.keysAPI().Get(ctx, key, &getOp)
+	cancel()
 
-	node := resp.PrevNode
-
-	if !node.Dir {
-		encoded, _ := json.Marshal(node.Value)
-		val = &value{
-			data: string(encoded),
-			ttl:  time.Duration(node.TTL) * time.Second, // todo: get ttl of specific key
-		}
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
-	return
+	return resp.Node, nil
 }
 
 func (b *bucket) mapKey(key string) string {
@@ -0,0 +1,70 @@
+package etcd
+
+import (
+	etcd "github.com/coreos/etcd/client"
+	"github.com/evo-cloud/cloudrt/jobs"
+	"golang.org/x/net/context"
+	"strconv"
+	"time"
+)
+
+// notifyTTL keeps the notification key from lingering
+const notifyTTL = time.Minute
+
+// Notify implements jobs.Notifier by touching the key of the channel
+func (s *Store) Notify(channel string) error {
+	ctx, cancel := requestContext()
+	defer cancel()
+	setOp := etcd.SetOptions{
+		TTL: notifyTTL,
+	}
+	_, err := s.keysAPI().Set(ctx, "/n/"+channel,
+		strconv.FormatInt(time.Now().UnixNano(), 10), &setOp)
+	return err
+}
+
+// Subscribe implements jobs.Notifier by watching the key of the
+// channel. The watch starts from the current index, so no notification
+// is missed after it returns.
+func (s *Store) Subscribe(channel string, stopCh jobs.StopChan) (<-chan struct{}, error) {
+	name := "/n/" + channel
+	api := s.keysAPI()
+	ctx, cancel := requestContext()
+	resp, err := api.Get(ctx, name, nil)
+	cancel()
+	var index uint64
+	if err == nil {
+		index = resp.Index
+	} else if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		index = err.(etcd.Error).Index
+	} else {
+		return nil, err
+	}
+
+	watcher := api.Watcher(name, &etcd.WatcherOptions{AfterIndex: index})
+	watchCtx, watchCancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-stopCh:
+		case <-watchCtx.Done():
+		}
+		watchCancel()
+	}()
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		defer watchCancel()
+		for {
+			// fails when the watch is canceled, or the index is
+			// cleared after a long disconnection
+			if _, err := watcher.Next(watchCtx); err != nil {
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
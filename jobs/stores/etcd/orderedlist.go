@@ -4,30 +4,33 @@ import (
 	"encoding/json"
 	etcd "github.com/coreos/etcd/client"
 	"github.com/evo-cloud/cloudrt/jobs"
-	"golang.org/x/net/context"
-	"log"
-	"time"
 )
 
+// orderedList keeps the keys in "order" directory created by
+// CreateInOrder, and "index" directory maps a key to its node
+// in "order" directory
 type orderedList struct {
 	name string
 	s    *Store
 }
 
 type orderedListEnum struct {
-	name   string
-	cursor int
-	count  int
-	end    bool
-	store  *Store
+	name    string
+	lastKey string
+	count   int
+	end     bool
+	store   *Store
 }
 
 func (l *orderedList) Enumerate(opts jobs.EnumOptions) jobs.Enumerator {
+	count := opts.PageSize
+	if count <= 0 {
+		count = 10
+	}
 	return &orderedListEnum{
-		name:   l.name,
-		cursor: 0,
-		count:  opts.PageSize,
-		store:  l.s,
+		name:  l.orderDir(),
+		count: count,
+		store: l.s,
 	}
 }
 
@@ -36,129 +39,120 @@ func (e *orderedListEnum) Next() ([]jobs.Value, error) {
 		return nil, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	api := etcd.NewKeysAPI(*e.store.Client)
+	ctx, cancel := requestContext()
 	getOp := etcd.GetOptions{
 		Sort:   true,
 		Quorum: true,
 	}
-
-	resp, err := api.Get(ctx, e.name, &getOp)
+	resp, err := e.store.keysAPI().Get(ctx, e.name, &getOp)
 	cancel()
 
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	respNode := resp.Node
-	if respNode.Dir {
-		nodes := respNode.Nodes
-		vals := make([]jobs.Value, 0, len(nodes))
-		counter := 0
-
-		// capture the slice defined by cursor and pageSize
-		for index, node := range nodes {
-			encoded, _ := json.Marshal(node.Value)
-
-			if (index >= e.cursor) && (counter < e.count) {
-				vals = append(vals, &value{
-					data: string(encoded),
-					ttl:  jobs.NoTTL,
-				})
-				counter++
-			} else if counter >= e.count {
-				break
-			} else if ((index + 1) == len(nodes)) && (counter <= e.count) {
-				e.end = true
-			}
-		}
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		e.end = true
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
 
-		if e.end {
-			e.cursor = 0
+	// keys created in order are sorted by creation
+	vals := make([]jobs.Value, 0, e.count)
+	for _, node := range resp.Node.Nodes {
+		if node.Dir || node.Key <= e.lastKey {
+			continue
 		}
-
-		if counter < e.count {
-			e.cursor = 0
-		} else {
-			e.cursor = e.cursor + e.count
+		if len(vals) >= e.count {
+			return vals, nil
 		}
-
-		return vals, nil
+		encoded, _ := json.Marshal(node.Value)
+		vals = append(vals, &value{
+			data: string(encoded),
+			ttl:  jobs.NoTTL,
+		})
+		e.lastKey = node.Key
 	}
-
-	return nil, nil
+	e.end = true
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	return vals, nil
 }
 
-func (l *orderedList) Set(id string, exist bool) (err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	api := etcd.NewKeysAPI(*l.s.Client)
+func (l *orderedList) Set(id string, exist bool) error {
 	if exist {
-		// add new value to list
-		createOp := etcd.CreateInOrderOptions{
-			TTL: time.Duration(-1),
-		}
-		_, err = api.CreateInOrder(ctx, l.name, id, &createOp)
-		cancel()
-	} else {
-		getOp := etcd.GetOptions{
-			Sort:   true,
-			Quorum: true,
-		}
-		resp, err := api.Get(ctx, l.name, &getOp)
-		cancel()
-		if err != nil {
-			log.Fatal(err)
-		}
+		return l.add(id)
+	}
+	return l.remove(id)
+}
 
-		node := resp.Node
-		if node.Dir {
-			nodes := node.Nodes
-			for _, node = range nodes {
-				if node.Value == id {
-					break
-				}
-			}
-		} else {
-			// something goes wrong here
-		}
+func (l *orderedList) Has(id string) (bool, error) {
+	ctx, cancel := requestContext()
+	getOp := etcd.GetOptions{
+		Quorum: true,
+	}
+	_, err := l.s.keysAPI().Get(ctx, l.indexKey(id), &getOp)
+	cancel()
 
-		delOp := etcd.DeleteOptions{
-			Dir: false,
-		}
-		_, err = api.Delete(ctx, node.Key, &delOp)
-		cancel()
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *orderedList) add(id string) error {
+	if has, err := l.Has(id); err != nil || has {
+		return err
 	}
+
+	api := l.s.keysAPI()
+	ctx, cancel := requestContext()
+	resp, err := api.CreateInOrder(ctx, l.orderDir(), id, nil)
+	cancel()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
+	ctx, cancel = requestContext()
+	setOp := etcd.SetOptions{
+		PrevExist: etcd.PrevNoExist,
+	}
+	_, err = api.Set(ctx, l.indexKey(id), resp.Node.Key, &setOp)
+	cancel()
+
+	if isErrorCode(err, etcd.ErrorCodeNodeExist) {
+		// added concurrently, keep the existing position
+		ctx, cancel = requestContext()
+		_, err = api.Delete(ctx, resp.Node.Key, nil)
+		cancel()
+	}
 	return err
 }
 
-func (l *orderedList) Has(id string) (bool, error) {
-	api := etcd.NewKeysAPI(*l.s.Client)
-	getOp := etcd.GetOptions{
-		Sort:   true,
-		Quorum: true,
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	resp, err := api.Get(ctx, l.name, &getOp)
+func (l *orderedList) remove(id string) error {
+	api := l.s.keysAPI()
+	ctx, cancel := requestContext()
+	resp, err := api.Delete(ctx, l.indexKey(id), nil)
 	cancel()
-	if err != nil {
-		log.Fatal(err)
+
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
 	}
 
-	node := resp.Node
-	if node.Dir {
-		nodes := node.Nodes
-		for _, node = range nodes {
-			if node.Value == id {
-				return true, nil
-			}
-		}
-	} else {
-		return false, nil
+	ctx, cancel = requestContext()
+	_, err = api.Delete(ctx, resp.PrevNode.Value, nil)
+	cancel()
+
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		return nil
 	}
+	return err
+}
+
+func (l *orderedList) orderDir() string {
+	return l.name + "/order"
+}
 
-	return false, err
+func (l *orderedList) indexKey(id string) string {
+	return l.name + "/index/" + id
 }
@@ -4,18 +4,22 @@ import (
 	"encoding/json"
 	etcd "github.com/coreos/etcd/client"
 	"github.com/evo-cloud/cloudrt/jobs"
-	"log"
+	"golang.org/x/net/context"
 	"time"
 )
 
-// Store is a store implementation backed by etcd
+// Store is a store implementation backed by etcd. It doesn't implement
+// jobs.Transactional as the v2 API has no multi-key transactions, the
+// writes are applied one by one.
 type Store struct {
 	Endpoints []string
 	Client    *etcd.Client
 }
 
+const requestTimeout = time.Second
+
 // NewStore creates a Store instance
-func NewStore(endpoints []string) *Store {
+func NewStore(endpoints []string) (*Store, error) {
 	s := &Store{
 		Endpoints: endpoints,
 	}
@@ -28,17 +32,17 @@ func NewStore(endpoints []string) *Store {
 
 	c, err := etcd.New(config)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	s.Client = &c
 
-	return s
+	return s, nil
 }
 
 // Bucket implements Store
 func (s *Store) Bucket(name string) jobs.PartitionedStore {
 	return &bucket{
-		prefix: name,
+		prefix: "/b/" + name + "/",
 		s:      s,
 	}
 }
@@ -46,7 +50,15 @@ func (s *Store) Bucket(name string) jobs.PartitionedStore {
 // OrderedList implements Store
 func (s *Store) OrderedList(name string) jobs.OrderedList {
 	return &orderedList{
-		name: name,
+		name: "/o/" + name,
+		s:    s,
+	}
+}
+
+// TimeIndex implements Store
+func (s *Store) TimeIndex(name string) jobs.TimeIndex {
+	return &timeIndex{
+		name: "/t/" + name,
 		s:    s,
 	}
 }
@@ -54,7 +66,7 @@ func (s *Store) OrderedList(name string) jobs.OrderedList {
 // Acquire implements Store
 func (s *Store) Acquire(name, ownerID string) (jobs.Acquisition, error) {
 	a := &acquisition{
-		name:  name,
+		name:  "/a/" + name,
 		owner: ownerID,
 		ttl:   10 * time.Second,
 		s:     s,
@@ -62,6 +74,27 @@ func (s *Store) Acquire(name, ownerID string) (jobs.Acquisition, error) {
 	return a, a.tryAcquire()
 }
 
+// TokenBucket implements Store
+func (s *Store) TokenBucket(name string) jobs.TokenBucket {
+	return &tokenBucket{
+		name: "/r/" + name,
+		s:    s,
+	}
+}
+
+func (s *Store) keysAPI() etcd.KeysAPI {
+	return etcd.NewKeysAPI(*s.Client)
+}
+
+func requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
+
+func isErrorCode(err error, code int) bool {
+	e, ok := err.(etcd.Error)
+	return ok && e.Code == code
+}
+
 type value struct {
 	data string
 	ttl  time.Duration
@@ -75,13 +108,21 @@ func (v *value) Unmarshal(out interface{}) error {
 	return json.Unmarshal([]byte(v.data), out)
 }
 
-func ttl2Dur(ttl int64, err error) time.Duration {
-	if err != nil || ttl < 0 {
-		return jobs.NoTTL
+func nodeValue(node *etcd.Node) *value {
+	v := &value{data: node.Value, ttl: jobs.NoTTL}
+	if node.Expiration != nil {
+		v.ttl = node.Expiration.Sub(time.Now())
+	} else if node.TTL > 0 {
+		v.ttl = time.Duration(node.TTL) * time.Second
 	}
-	return time.Duration(ttl) * time.Millisecond
+	return v
 }
 
-func dur2TTL(dur time.Duration) int64 {
-	return int64(dur / time.Millisecond)
+// ttlOption converts ttl to etcd TTL which is in seconds,
+// it's rounded up so the key never expires earlier
+func ttlOption(ttl time.Duration) time.Duration {
+	if ttl == jobs.Infinite || ttl <= 0 {
+		return 0
+	}
+	return (ttl + time.Second - 1) / time.Second * time.Second
 }
@@ -0,0 +1,24 @@
+package etcd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/evo-cloud/cloudrt/jobs/storetest"
+)
+
+// TestConformance runs against the comma separated endpoints in
+// ETCD_ENDPOINTS, e.g. http://localhost:2379, and is skipped if not
+// specified
+func TestConformance(t *testing.T) {
+	endpoints := os.Getenv("ETCD_ENDPOINTS")
+	if endpoints == "" {
+		t.Skip("ETCD_ENDPOINTS not specified")
+	}
+	store, err := NewStore(strings.Split(endpoints, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.Run(t, store)
+}
@@ -0,0 +1,246 @@
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	etcd "github.com/coreos/etcd/client"
+	"github.com/evo-cloud/cloudrt/jobs"
+	"net/url"
+	"path"
+	"time"
+)
+
+// timeSlotWidth is the time range of a slot directory
+const timeSlotWidth = time.Minute
+
+// timeIndex keeps keys in "time" directory named by the time and
+// the key so they are sorted by time, and "index" directory maps
+// a key to its node in "time" directory. As etcd v2 can't list a
+// range of a directory, the keys are grouped in slot directories by
+// time, and Due only fetches the slots it reaches.
+//
+// The writes are not atomic. A node in "time" directory is written
+// before the index points to it, and removed after the index no longer
+// does, so a node not pointed by the index is skipped by Due.
+type timeIndex struct {
+	name string
+	s    *Store
+}
+
+type timeIndexEnum struct {
+	index   *timeIndex
+	maxSlot string
+	maxKey  string
+	count   int
+
+	// slots are the slot directories to visit, fetched on first
+	// use, and nodes are the remaining keys of the current slot
+	slots   []string
+	nodes   []*etcd.Node
+	fetched bool
+}
+
+func (x *timeIndex) Set(id string, at time.Time) error {
+	api := x.s.keysAPI()
+	timeKey := x.timeDir() + "/" + timeSlotName(at) + "/" + timeKeyName(at) + "-" + url.QueryEscape(id)
+	ctx, cancel := requestContext()
+	_, err := api.Set(ctx, timeKey, id, nil)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel = requestContext()
+	resp, err := api.Set(ctx, x.indexKey(id), timeKey, nil)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if prev := resp.PrevNode; prev != nil && prev.Value != timeKey {
+		return x.removeTimeKey(prev.Value, 0)
+	}
+	return nil
+}
+
+func (x *timeIndex) Remove(id string) error {
+	api := x.s.keysAPI()
+	ctx, cancel := requestContext()
+	resp, err := api.Get(ctx, x.indexKey(id), &etcd.GetOptions{Quorum: true})
+	cancel()
+
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	timeKey := resp.Node.Value
+	if err = x.removeTimeKey(timeKey, 0); err != nil {
+		return err
+	}
+
+	// the index is kept if it's updated meanwhile
+	ctx, cancel = requestContext()
+	_, err = api.Delete(ctx, x.indexKey(id), &etcd.DeleteOptions{PrevValue: timeKey})
+	cancel()
+
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) || isErrorCode(err, etcd.ErrorCodeTestFailed) {
+		return nil
+	}
+	return err
+}
+
+// removeTimeKey removes the node in "time" directory, only if it's not
+// modified since prevIndex unless prevIndex is 0
+func (x *timeIndex) removeTimeKey(timeKey string, prevIndex uint64) error {
+	api := x.s.keysAPI()
+	ctx, cancel := requestContext()
+	_, err := api.Delete(ctx, timeKey, &etcd.DeleteOptions{PrevIndex: prevIndex})
+	cancel()
+
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) || isErrorCode(err, etcd.ErrorCodeTestFailed) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// the slot is removed once empty, it fails otherwise
+	ctx, cancel = requestContext()
+	api.Delete(ctx, path.Dir(timeKey), &etcd.DeleteOptions{Dir: true})
+	cancel()
+	return nil
+}
+
+func (x *timeIndex) Due(at time.Time, opts jobs.EnumOptions) jobs.Enumerator {
+	count := opts.PageSize
+	if count <= 0 {
+		count = 10
+	}
+	// all keys of the time are before the one with suffix "."
+	// which sorts after "-"
+	return &timeIndexEnum{
+		index:   x,
+		maxSlot: timeSlotName(at),
+		maxKey:  timeKeyName(at) + ".",
+		count:   count,
+	}
+}
+
+func (e *timeIndexEnum) Next() ([]jobs.Value, error) {
+	if !e.fetched {
+		nodes, err := e.list(e.index.timeDir())
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			if slot := path.Base(node.Key); node.Dir && slot <= e.maxSlot {
+				e.slots = append(e.slots, node.Key)
+			}
+		}
+		e.fetched = true
+	}
+
+	vals := make([]jobs.Value, 0, e.count)
+	for len(vals) < e.count {
+		if len(e.nodes) == 0 {
+			if len(e.slots) == 0 {
+				break
+			}
+			nodes, err := e.list(e.slots[0])
+			if err != nil {
+				return nil, err
+			}
+			e.slots, e.nodes = e.slots[1:], nodes
+			continue
+		}
+		node := e.nodes[0]
+		e.nodes = e.nodes[1:]
+		if node.Dir {
+			continue
+		}
+		if path.Base(node.Key) > e.maxKey {
+			// the keys after are all later
+			e.slots, e.nodes = nil, nil
+			break
+		}
+		live, err := e.index.pointsTo(node)
+		if err != nil {
+			return nil, err
+		}
+		if !live {
+			continue
+		}
+		encoded, _ := json.Marshal(node.Value)
+		vals = append(vals, &value{
+			data: string(encoded),
+			ttl:  jobs.NoTTL,
+		})
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	return vals, nil
+}
+
+// list fetches the sorted children of the directory
+func (e *timeIndexEnum) list(dir string) ([]*etcd.Node, error) {
+	ctx, cancel := requestContext()
+	getOp := etcd.GetOptions{
+		Sort:   true,
+		Quorum: true,
+	}
+	resp, err := e.index.s.keysAPI().Get(ctx, dir, &getOp)
+	cancel()
+
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return resp.Node.Nodes, nil
+}
+
+// pointsTo determines if the index points to the node in "time"
+// directory. A node left by a crashed Set is removed once the index is
+// updated after it, the one of a Set in progress is kept.
+func (x *timeIndex) pointsTo(node *etcd.Node) (bool, error) {
+	ctx, cancel := requestContext()
+	resp, err := x.s.keysAPI().Get(ctx, x.indexKey(node.Value), &etcd.GetOptions{Quorum: true})
+	cancel()
+
+	if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if resp.Node.Value == node.Key {
+		return true, nil
+	}
+	if resp.Node.ModifiedIndex > node.ModifiedIndex {
+		return false, x.removeTimeKey(node.Key, node.ModifiedIndex)
+	}
+	return false, nil
+}
+
+func (x *timeIndex) timeDir() string {
+	return x.name + "/time"
+}
+
+func (x *timeIndex) indexKey(id string) string {
+	return x.name + "/index/" + id
+}
+
+// orderedNano maps the time to an unsigned number in the same order,
+// including the times before 1970
+func orderedNano(t time.Time) uint64 {
+	return uint64(t.UnixNano()) ^ (1 << 63)
+}
+
+func timeKeyName(t time.Time) string {
+	return fmt.Sprintf("%020d", orderedNano(t))
+}
+
+func timeSlotName(t time.Time) string {
+	n := orderedNano(t)
+	return fmt.Sprintf("%020d", n-n%uint64(timeSlotWidth))
+}
@@ -0,0 +1,29 @@
+package etcd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeKeyOrder(t *testing.T) {
+	times := []time.Time{
+		time.Unix(-100, 0),
+		time.Unix(0, 0).Add(-time.Minute),
+		time.Unix(-1, 5),
+		time.Unix(0, 0),
+		time.Unix(0, 1),
+		time.Unix(1<<33, 0),
+	}
+	for i := 1; i < len(times); i++ {
+		prev, next := times[i-1], times[i]
+		if timeKeyName(prev) >= timeKeyName(next) {
+			t.Errorf("key of %v not before %v", prev, next)
+		}
+		if timeSlotName(prev) > timeSlotName(next) {
+			t.Errorf("slot of %v after %v", prev, next)
+		}
+		if timeSlotName(next) > timeKeyName(next) {
+			t.Errorf("slot of %v after its key", next)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package etcd
+
+import (
+	"encoding/json"
+	etcd "github.com/coreos/etcd/client"
+	"github.com/evo-cloud/cloudrt/jobs"
+	"time"
+)
+
+// tokenBucket keeps the state in a key updated by compare-and-swap
+type tokenBucket struct {
+	name string
+	s    *Store
+}
+
+func (b *tokenBucket) Take(limit jobs.RateLimit) (bool, error) {
+	if limit.Rate <= 0 {
+		return true, nil
+	}
+	return b.update(limit, func(state *jobs.TokenBucketState) bool {
+		return state.Take(limit, time.Now())
+	})
+}
+
+func (b *tokenBucket) Return(limit jobs.RateLimit) error {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	_, err := b.update(limit, func(state *jobs.TokenBucketState) bool {
+		state.Return(limit, time.Now())
+		return true
+	})
+	return err
+}
+
+// update applies fn to the state until the compare-and-swap succeeds
+func (b *tokenBucket) update(limit jobs.RateLimit, fn func(*jobs.TokenBucketState) bool) (bool, error) {
+	api := b.s.keysAPI()
+	for {
+		ctx, cancel := requestContext()
+		getOp := etcd.GetOptions{
+			Quorum: true,
+		}
+		resp, err := api.Get(ctx, b.name, &getOp)
+		cancel()
+
+		var state jobs.TokenBucketState
+		// the state is dropped when the bucket is full
+		setOp := etcd.SetOptions{
+			TTL: ttlOption(limit.RefillTime() + time.Second),
+		}
+		if isErrorCode(err, etcd.ErrorCodeKeyNotFound) {
+			setOp.PrevExist = etcd.PrevNoExist
+		} else if err != nil {
+			return false, err
+		} else {
+			if err = json.Unmarshal([]byte(resp.Node.Value), &state); err != nil {
+				return false, err
+			}
+			setOp.PrevIndex = resp.Node.ModifiedIndex
+		}
+
+		taken := fn(&state)
+		encoded, err := json.Marshal(&state)
+		if err != nil {
+			return false, err
+		}
+		ctx, cancel = requestContext()
+		_, err = api.Set(ctx, b.name, string(encoded), &setOp)
+		cancel()
+
+		if isErrorCode(err, etcd.ErrorCodeTestFailed) ||
+			isErrorCode(err, etcd.ErrorCodeNodeExist) {
+			// updated by others, try again
+			continue
+		}
+		return taken, err
+	}
+}
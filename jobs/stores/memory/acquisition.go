@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"fmt"
+	"time"
+)
+
+// lease is the ownership record of an acquired name
+type lease struct {
+	owner    string
+	expireAt time.Time
+}
+
+type acquisition struct {
+	name    string
+	owner   string
+	ownedBy string
+	ttl     time.Duration
+	store   *Store
+}
+
+func (a *acquisition) Acquired() bool {
+	return a.owner == a.ownedBy
+}
+
+func (a *acquisition) Owner() string {
+	return a.ownedBy
+}
+
+func (a *acquisition) TTL() time.Duration {
+	return a.ttl
+}
+
+func (a *acquisition) Refresh(ttl time.Duration) error {
+	return a.mustOwned(func(l *lease) {
+		a.ttl = ttl
+		l.expireAt = time.Now().Add(ttl)
+	})
+}
+
+func (a *acquisition) Release() error {
+	return a.mustOwned(func(*lease) {
+		delete(a.store.acquired, a.name)
+	})
+}
+
+func (a *acquisition) tryAcquire() error {
+	a.store.lock.Lock()
+	defer a.store.lock.Unlock()
+	l := a.lookup(time.Now())
+	if l == nil {
+		l = &lease{owner: a.owner}
+		a.store.acquired[a.name] = l
+	}
+	a.ownedBy = l.owner
+	if l.owner == a.owner {
+		l.expireAt = time.Now().Add(a.ttl)
+	}
+	return nil
+}
+
+// lookup finds an unexpired lease, store.lock must be held
+func (a *acquisition) lookup(now time.Time) *lease {
+	l := a.store.acquired[a.name]
+	if l != nil && !now.Before(l.expireAt) {
+		delete(a.store.acquired, a.name)
+		l = nil
+	}
+	return l
+}
+
+func (a *acquisition) mustOwned(fn func(*lease)) error {
+	a.store.lock.Lock()
+	defer a.store.lock.Unlock()
+	l := a.lookup(time.Now())
+	ownedBy := ""
+	if l != nil {
+		ownedBy = l.owner
+	}
+	if ownedBy != a.owner {
+		return fmt.Errorf("not owned by %s (owned by %s)", a.owner, ownedBy)
+	}
+	fn(l)
+	return nil
+}
@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// batch applies the writes under store.lock
+type batch struct {
+	store   *Store
+	expects []expectation
+	ops     []func()
+	err     error
+}
+
+type expectation struct {
+	bucket *bucket
+	key    string
+	val    jobs.Value
+}
+
+// Batch implements jobs.Transactional
+func (s *Store) Batch() jobs.Batch {
+	return &batch{store: s}
+}
+
+func (b *batch) Expect(name, key string, val jobs.Value) {
+	b.expects = append(b.expects, expectation{
+		bucket: &bucket{name: name, store: b.store},
+		key:    key,
+		val:    val,
+	})
+}
+
+func (b *batch) Put(name, key string, value interface{}, ttl time.Duration) {
+	ent, err := newEntry(value, ttl)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return
+	}
+	bkt := &bucket{name: name, store: b.store}
+	b.ops = append(b.ops, func() {
+		bkt.partition(jobs.Partition(key), true)[key] = ent
+	})
+}
+
+func (b *batch) SetKey(name, id string, exist bool) {
+	l := &orderedList{name: name, store: b.store}
+	b.ops = append(b.ops, func() {
+		l.set(id, exist)
+	})
+}
+
+func (b *batch) SetTime(name, id string, at time.Time) {
+	x := &timeIndex{name: name, store: b.store}
+	b.ops = append(b.ops, func() {
+		x.set(id, at)
+	})
+}
+
+func (b *batch) RemoveTime(name, id string) {
+	x := &timeIndex{name: name, store: b.store}
+	b.ops = append(b.ops, func() {
+		x.remove(id)
+	})
+}
+
+func (b *batch) Commit() error {
+	if b.err != nil {
+		return b.err
+	}
+	b.store.lock.Lock()
+	defer b.store.lock.Unlock()
+	now := time.Now()
+	for _, e := range b.expects {
+		if !e.holds(now) {
+			return jobs.ErrConflict
+		}
+	}
+	for _, op := range b.ops {
+		op()
+	}
+	return nil
+}
+
+// holds compares the current value with the expected one,
+// store.lock must be held
+func (e *expectation) holds(now time.Time) bool {
+	ent := e.bucket.lookup(e.key, now)
+	if e.val == nil || ent == nil {
+		return e.val == nil && ent == nil
+	}
+	val, ok := e.val.(*value)
+	return ok && bytes.Equal(val.data, ent.data)
+}
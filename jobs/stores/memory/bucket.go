@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+type bucket struct {
+	name  string
+	store *Store
+}
+
+type bucketEnum struct {
+	bucket    *bucket
+	partition int
+	count     int
+	lastKey   string
+	started   bool
+	end       bool
+}
+
+func (b *bucket) Enumerate(opts jobs.EnumOptions) jobs.Enumerator {
+	return &bucketEnum{
+		bucket:    b,
+		partition: opts.Partition,
+		count:     pageSize(opts),
+	}
+}
+
+func (e *bucketEnum) Next() ([]jobs.Value, error) {
+	if e.end {
+		return nil, nil
+	}
+	s := e.bucket.store
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	items := e.bucket.partition(e.partition, false)
+	keys := make([]string, 0, len(items))
+	for key, ent := range items {
+		if ent.expired(now) {
+			delete(items, key)
+		} else if !e.started || key > e.lastKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) <= e.count {
+		e.end = true
+	} else {
+		keys = keys[:e.count]
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	vals := make([]jobs.Value, 0, len(keys))
+	for _, key := range keys {
+		vals = append(vals, items[key].value(now))
+	}
+	e.started = true
+	e.lastKey = keys[len(keys)-1]
+	return vals, nil
+}
+
+func (b *bucket) Put(key string, value interface{}, ttl time.Duration) error {
+	ent, err := newEntry(value, ttl)
+	if err != nil {
+		return err
+	}
+	b.store.lock.Lock()
+	b.partition(jobs.Partition(key), true)[key] = ent
+	b.store.lock.Unlock()
+	return nil
+}
+
+func (b *bucket) Get(key string) (jobs.Value, error) {
+	b.store.lock.Lock()
+	defer b.store.lock.Unlock()
+	now := time.Now()
+	if ent := b.lookup(key, now); ent != nil {
+		return ent.value(now), nil
+	}
+	return nil, nil
+}
+
+func (b *bucket) Expire(key string, ttl time.Duration) error {
+	b.store.lock.Lock()
+	defer b.store.lock.Unlock()
+	if ent := b.lookup(key, time.Now()); ent != nil {
+		ent.expire(ttl)
+	}
+	return nil
+}
+
+func (b *bucket) Remove(key string) (jobs.Value, error) {
+	b.store.lock.Lock()
+	defer b.store.lock.Unlock()
+	now := time.Now()
+	ent := b.lookup(key, now)
+	if ent == nil {
+		return nil, nil
+	}
+	delete(b.partition(jobs.Partition(key), false), key)
+	return ent.value(now), nil
+}
+
+// lookup finds an unexpired entry, store.lock must be held
+func (b *bucket) lookup(key string, now time.Time) *entry {
+	items := b.partition(jobs.Partition(key), false)
+	ent := items[key]
+	if ent != nil && ent.expired(now) {
+		delete(items, key)
+		ent = nil
+	}
+	return ent
+}
+
+// partition returns the items in a partition, store.lock must be held
+func (b *bucket) partition(partition int, create bool) map[string]*entry {
+	partitions := b.store.buckets[b.name]
+	if partitions == nil {
+		if !create {
+			return nil
+		}
+		partitions = make(map[int]map[string]*entry)
+		b.store.buckets[b.name] = partitions
+	}
+	items := partitions[partition]
+	if items == nil && create {
+		items = make(map[string]*entry)
+		partitions[partition] = items
+	}
+	return items
+}
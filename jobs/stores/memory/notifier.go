@@ -0,0 +1,40 @@
+package memory
+
+import "github.com/evo-cloud/cloudrt/jobs"
+
+// Notify implements jobs.Notifier
+func (s *Store) Notify(channel string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for ch := range s.subscribers[channel] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe implements jobs.Notifier
+func (s *Store) Subscribe(channel string, stopCh jobs.StopChan) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+	s.lock.Lock()
+	subs := s.subscribers[channel]
+	if subs == nil {
+		subs = make(map[chan struct{}]struct{})
+		s.subscribers[channel] = subs
+	}
+	subs[ch] = struct{}{}
+	s.lock.Unlock()
+	go func() {
+		<-stopCh
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(subs, ch)
+		if len(subs) == 0 {
+			delete(s.subscribers, channel)
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+type orderedList struct {
+	name  string
+	store *Store
+}
+
+// listData keeps the insertion sequence of each key
+type listData struct {
+	keys map[string]uint64
+}
+
+type orderedListEnum struct {
+	list    *orderedList
+	count   int
+	lastSeq uint64
+	end     bool
+}
+
+func (l *orderedList) Enumerate(opts jobs.EnumOptions) jobs.Enumerator {
+	return &orderedListEnum{list: l, count: pageSize(opts)}
+}
+
+func (e *orderedListEnum) Next() ([]jobs.Value, error) {
+	if e.end {
+		return nil, nil
+	}
+	s := e.list.store
+	s.lock.Lock()
+	data := e.list.data(false)
+	var seqs sequences
+	ids := make(map[uint64]string)
+	if data != nil {
+		for id, seq := range data.keys {
+			if seq > e.lastSeq {
+				seqs = append(seqs, seq)
+				ids[seq] = id
+			}
+		}
+	}
+	s.lock.Unlock()
+
+	sort.Sort(seqs)
+	if len(seqs) <= e.count {
+		e.end = true
+	} else {
+		seqs = seqs[:e.count]
+	}
+	if len(seqs) == 0 {
+		return nil, nil
+	}
+	vals := make([]jobs.Value, 0, len(seqs))
+	for _, seq := range seqs {
+		encoded, _ := json.Marshal(ids[seq])
+		vals = append(vals, &value{data: encoded, ttl: jobs.NoTTL})
+	}
+	e.lastSeq = seqs[len(seqs)-1]
+	return vals, nil
+}
+
+func (l *orderedList) Set(id string, exist bool) error {
+	l.store.lock.Lock()
+	defer l.store.lock.Unlock()
+	l.set(id, exist)
+	return nil
+}
+
+// set adds or removes the key, store.lock must be held
+func (l *orderedList) set(id string, exist bool) {
+	if exist {
+		data := l.data(true)
+		if _, ok := data.keys[id]; !ok {
+			data.keys[id] = l.store.nextSequence()
+		}
+	} else if data := l.data(false); data != nil {
+		delete(data.keys, id)
+	}
+}
+
+func (l *orderedList) Has(id string) (bool, error) {
+	l.store.lock.Lock()
+	defer l.store.lock.Unlock()
+	if data := l.data(false); data != nil {
+		_, ok := data.keys[id]
+		return ok, nil
+	}
+	return false, nil
+}
+
+// data returns the list content, store.lock must be held
+func (l *orderedList) data(create bool) *listData {
+	data := l.store.lists[l.name]
+	if data == nil && create {
+		data = &listData{keys: make(map[string]uint64)}
+		l.store.lists[l.name] = data
+	}
+	return data
+}
+
+type sequences []uint64
+
+func (s sequences) Len() int           { return len(s) }
+func (s sequences) Less(i, j int) bool { return s[i] < s[j] }
+func (s sequences) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
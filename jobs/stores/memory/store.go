@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// Store is an in-process store implementation, useful for
+// tests and single-process deployments
+type Store struct {
+	lock        sync.Mutex
+	buckets     map[string]map[int]map[string]*entry
+	lists       map[string]*listData
+	indices     map[string]map[string]time.Time
+	acquired    map[string]*lease
+	tokens      map[string]*jobs.TokenBucketState
+	subscribers map[string]map[chan struct{}]struct{}
+	sequence    uint64
+}
+
+// NewStore creates a Store instance
+func NewStore() *Store {
+	return &Store{
+		buckets:     make(map[string]map[int]map[string]*entry),
+		lists:       make(map[string]*listData),
+		indices:     make(map[string]map[string]time.Time),
+		acquired:    make(map[string]*lease),
+		tokens:      make(map[string]*jobs.TokenBucketState),
+		subscribers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Bucket implements Store
+func (s *Store) Bucket(name string) jobs.PartitionedStore {
+	return &bucket{name: name, store: s}
+}
+
+// OrderedList implements Store
+func (s *Store) OrderedList(name string) jobs.OrderedList {
+	return &orderedList{name: name, store: s}
+}
+
+// TimeIndex implements Store
+func (s *Store) TimeIndex(name string) jobs.TimeIndex {
+	return &timeIndex{name: name, store: s}
+}
+
+// Acquire implements Store
+func (s *Store) Acquire(name, ownerID string) (jobs.Acquisition, error) {
+	a := &acquisition{name: name, owner: ownerID, store: s, ttl: 10 * time.Second}
+	return a, a.tryAcquire()
+}
+
+// TokenBucket implements Store
+func (s *Store) TokenBucket(name string) jobs.TokenBucket {
+	return &tokenBucket{name: name, store: s}
+}
+
+func (s *Store) nextSequence() uint64 {
+	s.sequence++
+	return s.sequence
+}
+
+// entry is a stored item with optional expiration
+type entry struct {
+	data     []byte
+	expireAt time.Time
+}
+
+func newEntry(value interface{}, ttl time.Duration) (*entry, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	e := &entry{data: encoded}
+	e.expire(ttl)
+	return e, nil
+}
+
+func (e *entry) expire(ttl time.Duration) {
+	if ttl == jobs.Infinite {
+		e.expireAt = time.Time{}
+	} else {
+		e.expireAt = time.Now().Add(ttl)
+	}
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+func (e *entry) value(now time.Time) *value {
+	v := &value{data: e.data, ttl: jobs.NoTTL}
+	if !e.expireAt.IsZero() {
+		v.ttl = e.expireAt.Sub(now)
+	}
+	return v
+}
+
+type value struct {
+	data []byte
+	ttl  time.Duration
+}
+
+func (v *value) TTL() time.Duration {
+	return v.ttl
+}
+
+func (v *value) Unmarshal(out interface{}) error {
+	return json.Unmarshal(v.data, out)
+}
+
+func pageSize(opts jobs.EnumOptions) int {
+	if opts.PageSize <= 0 {
+		return 10
+	}
+	return opts.PageSize
+}
@@ -0,0 +1,12 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	(&storetest.Suite{Store: NewStore(), TTL: 200 * time.Millisecond}).Run(t)
+}
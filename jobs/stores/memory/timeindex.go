@@ -0,0 +1,108 @@
+package memory
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+type timeIndex struct {
+	name  string
+	store *Store
+}
+
+type timeIndexEnum struct {
+	index   *timeIndex
+	at      time.Time
+	count   int
+	last    timedKey
+	started bool
+	end     bool
+}
+
+type timedKey struct {
+	id string
+	at time.Time
+}
+
+func (k timedKey) before(o timedKey) bool {
+	if k.at.Equal(o.at) {
+		return k.id < o.id
+	}
+	return k.at.Before(o.at)
+}
+
+type timedKeys []timedKey
+
+func (k timedKeys) Len() int           { return len(k) }
+func (k timedKeys) Less(i, j int) bool { return k[i].before(k[j]) }
+func (k timedKeys) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+
+func (x *timeIndex) Set(id string, at time.Time) error {
+	x.store.lock.Lock()
+	defer x.store.lock.Unlock()
+	x.set(id, at)
+	return nil
+}
+
+func (x *timeIndex) Remove(id string) error {
+	x.store.lock.Lock()
+	defer x.store.lock.Unlock()
+	x.remove(id)
+	return nil
+}
+
+// set adds or updates the key, store.lock must be held
+func (x *timeIndex) set(id string, at time.Time) {
+	keys := x.store.indices[x.name]
+	if keys == nil {
+		keys = make(map[string]time.Time)
+		x.store.indices[x.name] = keys
+	}
+	keys[id] = at
+}
+
+// remove removes the key, store.lock must be held
+func (x *timeIndex) remove(id string) {
+	delete(x.store.indices[x.name], id)
+}
+
+func (x *timeIndex) Due(at time.Time, opts jobs.EnumOptions) jobs.Enumerator {
+	return &timeIndexEnum{index: x, at: at, count: pageSize(opts)}
+}
+
+func (e *timeIndexEnum) Next() ([]jobs.Value, error) {
+	if e.end {
+		return nil, nil
+	}
+	s := e.index.store
+	s.lock.Lock()
+	var keys timedKeys
+	for id, at := range s.indices[e.index.name] {
+		key := timedKey{id: id, at: at}
+		if !at.After(e.at) && (!e.started || e.last.before(key)) {
+			keys = append(keys, key)
+		}
+	}
+	s.lock.Unlock()
+
+	sort.Sort(keys)
+	if len(keys) <= e.count {
+		e.end = true
+	} else {
+		keys = keys[:e.count]
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	vals := make([]jobs.Value, 0, len(keys))
+	for _, key := range keys {
+		encoded, _ := json.Marshal(key.id)
+		vals = append(vals, &value{data: encoded, ttl: jobs.NoTTL})
+	}
+	e.started = true
+	e.last = keys[len(keys)-1]
+	return vals, nil
+}
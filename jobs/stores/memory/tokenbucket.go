@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+type tokenBucket struct {
+	name  string
+	store *Store
+}
+
+func (b *tokenBucket) Take(limit jobs.RateLimit) (bool, error) {
+	if limit.Rate <= 0 {
+		return true, nil
+	}
+	b.store.lock.Lock()
+	defer b.store.lock.Unlock()
+	return b.state().Take(limit, time.Now()), nil
+}
+
+func (b *tokenBucket) Return(limit jobs.RateLimit) error {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	b.store.lock.Lock()
+	defer b.store.lock.Unlock()
+	b.state().Return(limit, time.Now())
+	return nil
+}
+
+// state must be called with store.lock held
+func (b *tokenBucket) state() *jobs.TokenBucketState {
+	state := b.store.tokens[b.name]
+	if state == nil {
+		state = &jobs.TokenBucketState{}
+		b.store.tokens[b.name] = state
+	}
+	return state
+}
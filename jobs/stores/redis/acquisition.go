@@ -30,9 +30,12 @@ func (a *acquisition) TTL() time.Duration {
 func (a *acquisition) Refresh(ttl time.Duration) error {
 	return a.mustOwned(func(conn redis.Conn) error {
 		conn.Send("MULTI")
-		conn.Send("PEXPIRE", a.key(), dur2TTL(a.ttl))
-		_, err := conn.Do("EXEC")
-		return err
+		conn.Send("PEXPIRE", a.key(), dur2TTL(ttl))
+		if _, err := redis.Values(conn.Do("EXEC")); err != nil {
+			return err
+		}
+		a.ttl = ttl
+		return nil
 	})
 }
 
@@ -40,7 +43,7 @@ func (a *acquisition) Release() error {
 	return a.mustOwned(func(conn redis.Conn) error {
 		conn.Send("MULTI")
 		conn.Send("DEL", a.key())
-		_, err := conn.Do("EXEC")
+		_, err := redis.Values(conn.Do("EXEC"))
 		return err
 	})
 }
@@ -63,7 +66,7 @@ func (a *acquisition) tryAcquire() error {
 		}
 	}
 	if a.ownedBy != "" && a.ownedBy != a.owner {
-		_, err = conn.Do("DISCARD")
+		_, err = conn.Do("UNWATCH")
 		return err
 	}
 	conn.Send("MULTI")
@@ -82,7 +85,7 @@ func (a *acquisition) tryAcquire() error {
 }
 
 func (a *acquisition) key() string {
-	return a.name + ":" + a.owner
+	return a.name
 }
 
 func (a *acquisition) mustOwned(fn func(conn redis.Conn) error) error {
@@ -94,11 +97,11 @@ func (a *acquisition) mustOwned(fn func(conn redis.Conn) error) error {
 		return err
 	}
 	ownedBy, err := redis.String(conn.Do("HGET", key, "owner"))
-	if err != nil {
+	if err != nil && err != redis.ErrNil {
 		return err
 	}
 	if ownedBy != a.owner {
-		conn.Do("DISCARD")
+		conn.Do("UNWATCH")
 		return fmt.Errorf("not owned by %s (owned by %s)", a.owner, ownedBy)
 	}
 	return fn(conn)
@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+	redis "github.com/garyburd/redigo/redis"
+)
+
+// batch queues the writes in MULTI/EXEC, and the expected keys are
+// watched so EXEC aborts if any of them is modified after checked
+type batch struct {
+	store   *Store
+	expects []expectation
+	cmds    []command
+	err     error
+}
+
+type expectation struct {
+	key string
+	val jobs.Value
+}
+
+type command struct {
+	name string
+	args []interface{}
+}
+
+// Batch implements jobs.Transactional
+func (s *Store) Batch() jobs.Batch {
+	return &batch{store: s}
+}
+
+func (b *batch) Expect(name, key string, val jobs.Value) {
+	b.expects = append(b.expects, expectation{
+		key: b.bucket(name).mapKey(key),
+		val: val,
+	})
+}
+
+func (b *batch) Put(name, key string, value interface{}, ttl time.Duration) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return
+	}
+	key = b.bucket(name).mapKey(key)
+	if ttl != jobs.Infinite {
+		b.add("PSETEX", key, dur2TTL(ttl), string(encoded))
+	} else {
+		b.add("SET", key, string(encoded))
+	}
+}
+
+func (b *batch) SetKey(name, id string, exist bool) {
+	list := b.store.OrderedList(name).(*orderedList)
+	if exist {
+		// NX keeps the original position of an existing key
+		b.add("ZADD", list.name, "NX", float64(time.Now().UnixNano()), id)
+	} else {
+		b.add("ZREM", list.name, id)
+	}
+}
+
+func (b *batch) SetTime(name, id string, at time.Time) {
+	b.add("ZADD", b.store.TimeIndex(name).(*timeIndex).name, time2Score(at), id)
+}
+
+func (b *batch) RemoveTime(name, id string) {
+	b.add("ZREM", b.store.TimeIndex(name).(*timeIndex).name, id)
+}
+
+func (b *batch) Commit() error {
+	if b.err != nil {
+		return b.err
+	}
+	conn := b.store.connection()
+	// the pooled connection is unwatched when closed
+	defer conn.Close()
+	if len(b.expects) > 0 {
+		keys := make([]interface{}, 0, len(b.expects))
+		for _, e := range b.expects {
+			keys = append(keys, e.key)
+		}
+		if _, err := conn.Do("WATCH", keys...); err != nil {
+			return err
+		}
+		for _, e := range b.expects {
+			holds, err := e.holds(conn)
+			if err != nil {
+				return err
+			}
+			if !holds {
+				return jobs.ErrConflict
+			}
+		}
+	}
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	for _, cmd := range b.cmds {
+		if err := conn.Send(cmd.name, cmd.args...); err != nil {
+			return err
+		}
+	}
+	reply, err := conn.Do("EXEC")
+	if err == nil && reply == nil {
+		// aborted as a watched key is modified
+		err = jobs.ErrConflict
+	}
+	return err
+}
+
+func (b *batch) bucket(name string) *bucket {
+	return b.store.Bucket(name).(*bucket)
+}
+
+func (b *batch) add(name string, args ...interface{}) {
+	b.cmds = append(b.cmds, command{name: name, args: args})
+}
+
+func (e *expectation) holds(conn redis.Conn) (bool, error) {
+	reply, err := redis.String(conn.Do("GET", e.key))
+	if err == redis.ErrNil {
+		return e.val == nil, nil
+	} else if err != nil {
+		return false, err
+	}
+	val, ok := e.val.(*value)
+	return ok && val.data == reply, nil
+}
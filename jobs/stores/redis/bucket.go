@@ -59,7 +59,10 @@ func (e *bucketEnum) Next() ([]jobs.Value, error) {
 	vals := make([]jobs.Value, 0, len(keys))
 	for _, key := range keys {
 		reply, err := redis.String(conn.Do("GET", key))
-		if err != nil {
+		if err == redis.ErrNil {
+			// expired or removed after scanned
+			continue
+		} else if err != nil {
 			return vals, err
 		}
 		vals = append(vals, &value{
@@ -91,7 +94,9 @@ func (b *bucket) Get(key string) (jobs.Value, error) {
 	conn := b.store.connection()
 	defer conn.Close()
 	reply, err := redis.String(conn.Do("GET", key))
-	if err != nil {
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
 	return &value{
@@ -104,7 +109,7 @@ func (b *bucket) Expire(key string, ttl time.Duration) (err error) {
 	key = b.mapKey(key)
 	conn := b.store.connection()
 	defer conn.Close()
-	if ttl != jobs.NoTTL {
+	if ttl == jobs.NoTTL {
 		_, err = conn.Do("PERSIST", key)
 	} else {
 		_, err = conn.Do("PEXPIRE", key, dur2TTL(ttl))
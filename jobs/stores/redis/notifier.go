@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"github.com/evo-cloud/cloudrt/jobs"
+	redis "github.com/garyburd/redigo/redis"
+)
+
+// Notify implements jobs.Notifier using PUBLISH
+func (s *Store) Notify(channel string) error {
+	conn := s.connection()
+	defer conn.Close()
+	_, err := conn.Do("PUBLISH", "n:"+channel, "")
+	return err
+}
+
+// Subscribe implements jobs.Notifier. A dedicated connection is used
+// as a subscribed connection can't be returned to the pool, and it
+// returns after the subscription is confirmed so no notification
+// published afterwards is missed.
+func (s *Store) Subscribe(channel string, stopCh jobs.StopChan) (<-chan struct{}, error) {
+	conn, err := s.pool.Dial()
+	if err != nil {
+		return nil, err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	if err = psc.Subscribe("n:" + channel); err == nil {
+		switch v := psc.Receive().(type) {
+		case redis.Subscription:
+		case error:
+			err = v
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ch := make(chan struct{}, 1)
+	doneCh := make(chan struct{})
+	go func() {
+		select {
+		case <-stopCh:
+		case <-doneCh:
+		}
+		// unblocks Receive
+		conn.Close()
+	}()
+	go func() {
+		defer close(ch)
+		defer close(doneCh)
+		for {
+			switch psc.Receive().(type) {
+			case redis.Message:
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			case error:
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
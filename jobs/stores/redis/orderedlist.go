@@ -14,19 +14,30 @@ type orderedList struct {
 }
 
 type orderedListEnum struct {
-	name   string
-	cursor int
-	count  int
-	end    bool
-	store  *Store
+	name  string
+	score string
+	max   string
+	skip  int
+	count int
+	end   bool
+	store *Store
 }
 
 func (l *orderedList) Enumerate(opts jobs.EnumOptions) jobs.Enumerator {
+	return newOrderedListEnum(l.name, "+inf", opts, l.store)
+}
+
+func newOrderedListEnum(name, max string, opts jobs.EnumOptions, store *Store) *orderedListEnum {
+	count := opts.PageSize
+	if count <= 0 {
+		count = 10
+	}
 	return &orderedListEnum{
-		name:   l.name,
-		cursor: 0,
-		count:  opts.PageSize,
-		store:  l.store,
+		name:  name,
+		score: "-inf",
+		max:   max,
+		count: count,
+		store: store,
 	}
 }
 
@@ -36,33 +47,27 @@ func (e *orderedListEnum) Next() ([]jobs.Value, error) {
 	}
 	conn := e.store.connection()
 	defer conn.Close()
-	replies, err := redis.Values(conn.Do(
-		"ZSCAN", e.name, e.cursor,
-		"COUNT", e.count))
-	if err != nil {
-		return nil, err
-	}
-	cursor, err := redis.Int(replies[0], nil)
+	// ZSCAN doesn't keep the order, so page by score, and skip the
+	// items already returned having the same score as the last one
+	items, err := redis.Strings(conn.Do(
+		"ZRANGEBYSCORE", e.name, e.score, e.max,
+		"WITHSCORES", "LIMIT", e.skip, e.count))
 	if err != nil {
 		return nil, err
 	}
-	items, err := redis.Values(replies[1], nil)
-	if err != nil {
-		return nil, err
-	}
-	e.cursor = cursor
-	if cursor == 0 {
+	if len(items) < e.count*2 {
 		e.end = true
 	}
-	vals := make([]jobs.Value, 0, len(items))
-	for _, item := range items {
-		arr, ok := item.([]interface{})
-		if !ok || len(arr) == 0 {
-			continue
-		}
-		key, ok := arr[0].(string)
-		if !ok {
-			continue
+	if len(items) == 0 {
+		return nil, nil
+	}
+	vals := make([]jobs.Value, 0, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		key, score := items[i], items[i+1]
+		if score == e.score {
+			e.skip++
+		} else {
+			e.score, e.skip = score, 1
 		}
 		encoded, _ := json.Marshal(&key)
 		vals = append(vals, &value{
@@ -77,7 +82,8 @@ func (l *orderedList) Set(id string, exist bool) (err error) {
 	conn := l.store.connection()
 	defer conn.Close()
 	if exist {
-		_, err = conn.Do("ZADD", l.name, float64(time.Now().UnixNano()), id)
+		// NX keeps the original position of an existing key
+		_, err = conn.Do("ZADD", l.name, "NX", float64(time.Now().UnixNano()), id)
 	} else {
 		_, err = conn.Do("ZREM", l.name, id)
 	}
@@ -87,6 +93,9 @@ func (l *orderedList) Set(id string, exist bool) (err error) {
 func (l *orderedList) Has(id string) (bool, error) {
 	conn := l.store.connection()
 	defer conn.Close()
-	score, err := redis.Float64(conn.Do("ZSCORE", l.name, id))
-	return score != 0, err
+	_, err := redis.Float64(conn.Do("ZSCORE", l.name, id))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
 }
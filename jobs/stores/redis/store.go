@@ -56,12 +56,22 @@ func (s *Store) OrderedList(name string) jobs.OrderedList {
 	return &orderedList{name: "o:" + name, store: s}
 }
 
+// TimeIndex implements Store
+func (s *Store) TimeIndex(name string) jobs.TimeIndex {
+	return &timeIndex{name: "t:" + name, store: s}
+}
+
 // Acquire implements Store
 func (s *Store) Acquire(name, ownerID string) (jobs.Acquisition, error) {
 	a := &acquisition{name: "a:" + name, owner: ownerID, store: s, ttl: 10 * time.Second}
 	return a, a.tryAcquire()
 }
 
+// TokenBucket implements Store
+func (s *Store) TokenBucket(name string) jobs.TokenBucket {
+	return &tokenBucket{name: "r:" + name, store: s}
+}
+
 func (s *Store) connection() redis.Conn {
 	return s.pool.Get()
 }
@@ -0,0 +1,18 @@
+package redis
+
+import (
+	"os"
+	"testing"
+
+	"github.com/evo-cloud/cloudrt/jobs/storetest"
+)
+
+// TestConformance runs against the server in REDIS_SERVER, e.g.
+// localhost:6379, and is skipped if not specified
+func TestConformance(t *testing.T) {
+	server := os.Getenv("REDIS_SERVER")
+	if server == "" {
+		t.Skip("REDIS_SERVER not specified")
+	}
+	storetest.Run(t, NewStore(server))
+}
@@ -0,0 +1,36 @@
+package redis
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// timeIndex is a sorted set scored by time in milliseconds
+type timeIndex struct {
+	name  string
+	store *Store
+}
+
+func (x *timeIndex) Set(id string, at time.Time) error {
+	conn := x.store.connection()
+	defer conn.Close()
+	_, err := conn.Do("ZADD", x.name, time2Score(at), id)
+	return err
+}
+
+func (x *timeIndex) Remove(id string) error {
+	conn := x.store.connection()
+	defer conn.Close()
+	_, err := conn.Do("ZREM", x.name, id)
+	return err
+}
+
+func (x *timeIndex) Due(at time.Time, opts jobs.EnumOptions) jobs.Enumerator {
+	return newOrderedListEnum(x.name, strconv.FormatInt(time2Score(at), 10), opts, x.store)
+}
+
+func time2Score(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
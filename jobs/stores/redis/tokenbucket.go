@@ -0,0 +1,72 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+	redis "github.com/garyburd/redigo/redis"
+)
+
+// tokenBucket is a hash of tokens and the refill time in milliseconds,
+// updated atomically by a script. The time is from the client, as the
+// script must be deterministic.
+type tokenBucket struct {
+	name  string
+	store *Store
+}
+
+// updateTokensScript takes a token if ARGV[5] is -1, or puts back
+// a token if it's 1
+var updateTokensScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local delta = tonumber(ARGV[5])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(state[1])
+local at = tonumber(state[2])
+if tokens == nil or at == nil then
+	tokens = capacity
+	at = now
+elseif now > at then
+	tokens = math.min(capacity, tokens + (now - at) * rate / 1000)
+	at = now
+end
+local taken = 0
+if delta > 0 then
+	tokens = math.min(capacity, tokens + delta)
+	taken = 1
+elseif tokens >= 1 then
+	tokens = tokens - 1
+	taken = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'at', tostring(at))
+redis.call('PEXPIRE', KEYS[1], ttl)
+return taken
+`)
+
+func (b *tokenBucket) Take(limit jobs.RateLimit) (bool, error) {
+	if limit.Rate <= 0 {
+		return true, nil
+	}
+	return b.update(limit, -1)
+}
+
+func (b *tokenBucket) Return(limit jobs.RateLimit) error {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	_, err := b.update(limit, 1)
+	return err
+}
+
+func (b *tokenBucket) update(limit jobs.RateLimit, delta int) (bool, error) {
+	conn := b.store.connection()
+	defer conn.Close()
+	// the state is dropped when the bucket is full
+	ttl := dur2TTL(limit.RefillTime() + time.Second)
+	taken, err := redis.Int(updateTokensScript.Do(conn, b.name,
+		limit.Rate, limit.Capacity(), time2Score(time.Now()), ttl, delta))
+	return taken == 1, err
+}
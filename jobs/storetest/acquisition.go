@@ -0,0 +1,87 @@
+package storetest
+
+import (
+	"testing"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// TestAcquisition verifies the contract of jobs.Acquisition
+func (s *Suite) TestAcquisition(t *testing.T) {
+	t.Run("Exclusive", s.testAcquireExclusive)
+	t.Run("NonOwner", s.testAcquireNonOwner)
+	t.Run("Release", s.testAcquireRelease)
+	t.Run("Expire", s.testAcquireExpire)
+}
+
+func (s *Suite) testAcquireExclusive(t *testing.T) {
+	name := s.name("acquire-exclusive")
+	a := s.acquire(t, name, "owner1", true, "owner1")
+	defer a.Release()
+	s.acquire(t, name, "owner2", false, "owner1")
+	s.acquire(t, name, "owner1", true, "owner1")
+}
+
+func (s *Suite) testAcquireNonOwner(t *testing.T) {
+	name := s.name("acquire-non-owner")
+	a := s.acquire(t, name, "owner1", true, "owner1")
+	defer a.Release()
+	b := s.acquire(t, name, "owner2", false, "owner1")
+	if err := b.Refresh(s.ttl()); err == nil {
+		t.Error("Refresh by non-owner succeeded")
+	}
+	if err := b.Release(); err == nil {
+		t.Error("Release by non-owner succeeded")
+	}
+	s.acquire(t, name, "owner3", false, "owner1")
+}
+
+func (s *Suite) testAcquireRelease(t *testing.T) {
+	name := s.name("acquire-release")
+	a := s.acquire(t, name, "owner1", true, "owner1")
+	if err := a.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := a.Refresh(s.ttl()); err == nil {
+		t.Error("Refresh after Release succeeded")
+	}
+	b := s.acquire(t, name, "owner2", true, "owner2")
+	b.Release()
+}
+
+func (s *Suite) testAcquireExpire(t *testing.T) {
+	name := s.name("acquire-expire")
+	ttl := s.ttl()
+	a := s.acquire(t, name, "owner1", true, "owner1")
+	if err := a.Refresh(ttl); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if a.TTL() != ttl {
+		t.Errorf("TTL is %v after Refresh, expect %v", a.TTL(), ttl)
+	}
+	s.acquire(t, name, "owner2", false, "owner1")
+	s.waitExpire(ttl)
+	b := s.acquire(t, name, "owner2", true, "owner2")
+	defer b.Release()
+	if err := a.Refresh(ttl); err == nil {
+		t.Error("Refresh of expired acquisition succeeded")
+	}
+	if err := a.Release(); err == nil {
+		t.Error("Release of expired acquisition succeeded")
+	}
+	s.acquire(t, name, "owner3", false, "owner2")
+}
+
+func (s *Suite) acquire(t *testing.T, name, owner string, acquired bool, ownedBy string) jobs.Acquisition {
+	a, err := s.Store.Acquire(name, owner)
+	if err != nil {
+		t.Fatalf("Acquire %s by %s: %v", name, owner, err)
+	}
+	if a.Acquired() != acquired {
+		t.Fatalf("Acquire %s by %s: Acquired is %v, expect %v", name, owner, a.Acquired(), acquired)
+	}
+	if a.Owner() != ownedBy {
+		t.Fatalf("Acquire %s by %s: Owner is %s, expect %s", name, owner, a.Owner(), ownedBy)
+	}
+	return a
+}
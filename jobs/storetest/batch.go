@@ -0,0 +1,68 @@
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// TestBatch verifies the contract of jobs.Batch if the store
+// implements jobs.Transactional
+func (s *Suite) TestBatch(t *testing.T) {
+	store, ok := s.Store.(jobs.Transactional)
+	if !ok {
+		t.Skip("jobs.Transactional not implemented")
+	}
+	bucketName, listName, indexName := s.name("batch-bucket"), s.name("batch-list"), s.name("batch-index")
+	bucket := s.Store.Bucket(bucketName)
+	list := s.Store.OrderedList(listName)
+	index := s.Store.TimeIndex(indexName)
+	base := time.Now().Add(-time.Hour)
+
+	// all writes are applied
+	b := store.Batch()
+	b.Expect(bucketName, "key", nil)
+	b.Put(bucketName, "key", &record{Key: "key", Value: 1}, jobs.Infinite)
+	b.SetKey(listName, "id1", true)
+	b.SetTime(indexName, "id1", base)
+	b.SetTime(indexName, "id2", base.Add(time.Minute))
+	b.RemoveTime(indexName, "id2")
+	if err := b.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	v1 := expectRecord(t, bucket, "key", 1)
+	expectHas(t, list, "id1", true)
+	expectDue(t, index, time.Now(), []string{"id1"})
+
+	// nothing is written if an expectation fails
+	b = store.Batch()
+	b.Expect(bucketName, "key", nil)
+	b.Put(bucketName, "key", &record{Key: "key", Value: 2}, jobs.Infinite)
+	b.SetKey(listName, "id1", false)
+	b.SetTime(indexName, "id3", base)
+	if err := b.Commit(); err != jobs.ErrConflict {
+		t.Fatalf("Commit: %v, expect ErrConflict", err)
+	}
+	expectRecord(t, bucket, "key", 1)
+	expectHas(t, list, "id1", true)
+	expectDue(t, index, time.Now(), []string{"id1"})
+
+	// the value read before is expected
+	b = store.Batch()
+	b.Expect(bucketName, "key", v1)
+	b.Put(bucketName, "key", &record{Key: "key", Value: 2}, jobs.Infinite)
+	if err := b.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	expectRecord(t, bucket, "key", 2)
+
+	// the value is modified since read
+	b = store.Batch()
+	b.Expect(bucketName, "key", v1)
+	b.Put(bucketName, "key", &record{Key: "key", Value: 3}, jobs.Infinite)
+	if err := b.Commit(); err != jobs.ErrConflict {
+		t.Fatalf("Commit: %v, expect ErrConflict", err)
+	}
+	expectRecord(t, bucket, "key", 2)
+}
@@ -0,0 +1,65 @@
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// notifyTimeout is the max latency expected for a notification
+const notifyTimeout = 2 * time.Second
+
+// TestNotifier verifies the contract of jobs.Notifier if the store
+// implements it
+func (s *Suite) TestNotifier(t *testing.T) {
+	notifier, ok := s.Store.(jobs.Notifier)
+	if !ok {
+		t.Skip("jobs.Notifier not implemented")
+	}
+	channel := s.name("notifier")
+	stopCh := make(chan struct{})
+	ch, err := notifier.Subscribe(channel, stopCh)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	other, err := notifier.Subscribe(s.name("notifier-other"), stopCh)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// notifications published after Subscribe returns are delivered
+	if err = notifier.Notify(channel); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	select {
+	case _, ok := <-ch:
+		if !ok {
+			t.Fatal("subscription ended unexpectedly")
+		}
+	case <-time.After(notifyTimeout):
+		t.Fatal("notification not delivered")
+	}
+	select {
+	case <-other:
+		t.Error("notification delivered to other channel")
+	default:
+	}
+
+	// the chan is closed once stopped
+	close(stopCh)
+	for _, sub := range []<-chan struct{}{ch, other} {
+		timeout := time.After(notifyTimeout)
+	drain:
+		for {
+			select {
+			case _, ok := <-sub:
+				if !ok {
+					break drain
+				}
+			case <-timeout:
+				t.Fatal("subscription not ended after stop")
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package storetest
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// TestKeySet verifies the contract of jobs.KeySet
+func (s *Suite) TestKeySet(t *testing.T) {
+	l := s.Store.OrderedList(s.name("keyset"))
+	expectHas(t, l, "a", false)
+	if err := l.Set("a", false); err != nil {
+		t.Fatalf("Set missing key false: %v", err)
+	}
+	expectHas(t, l, "a", false)
+	for i := 0; i < 2; i++ {
+		if err := l.Set("a", true); err != nil {
+			t.Fatalf("Set true: %v", err)
+		}
+		expectHas(t, l, "a", true)
+	}
+	expectHas(t, l, "b", false)
+	if err := l.Set("a", false); err != nil {
+		t.Fatalf("Set false: %v", err)
+	}
+	expectHas(t, l, "a", false)
+}
+
+// TestOrderedList verifies the contract of jobs.OrderedList
+func (s *Suite) TestOrderedList(t *testing.T) {
+	l := s.Store.OrderedList(s.name("ordered"))
+	const count = 23
+	var ids []string
+	for i := 0; i < count; i++ {
+		id := "id" + strconv.Itoa(count-i)
+		if err := l.Set(id, true); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	expectOrder(t, l, ids)
+
+	// setting an existing key keeps its position
+	if err := l.Set(ids[0], true); err != nil {
+		t.Fatalf("Set existing: %v", err)
+	}
+	expectOrder(t, l, ids)
+
+	var remains []string
+	for i, id := range ids {
+		if i%3 == 0 {
+			if err := l.Set(id, false); err != nil {
+				t.Fatalf("Set false: %v", err)
+			}
+		} else {
+			remains = append(remains, id)
+		}
+	}
+	expectOrder(t, l, remains)
+}
+
+func expectHas(t *testing.T, l jobs.KeySet, id string, expected bool) {
+	has, err := l.Has(id)
+	if err != nil {
+		t.Fatalf("Has %s: %v", id, err)
+	}
+	if has != expected {
+		t.Fatalf("Has %s is %v, expect %v", id, has, expected)
+	}
+}
+
+func expectOrder(t *testing.T, l jobs.OrderedList, ids []string) {
+	e := l.Enumerate(jobs.EnumOptions{PageSize: 4})
+	var found []string
+	for {
+		vals, err := e.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if vals == nil {
+			break
+		}
+		for _, val := range vals {
+			var id string
+			if err = val.Unmarshal(&id); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			found = append(found, id)
+		}
+	}
+	if len(found) != len(ids) {
+		t.Fatalf("enumerated %v, expect %v", found, ids)
+	}
+	for i, id := range ids {
+		if found[i] != id {
+			t.Fatalf("enumerated %v, expect %v", found, ids)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package storetest
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+type record struct {
+	Key   string `json:"key"`
+	Value int    `json:"value"`
+}
+
+// TestPartitionedStore verifies the contract of jobs.PartitionedStore
+func (s *Suite) TestPartitionedStore(t *testing.T) {
+	t.Run("GetMissing", s.testBucketGetMissing)
+	t.Run("PutGet", s.testBucketPutGet)
+	t.Run("TTL", s.testBucketTTL)
+	t.Run("Expire", s.testBucketExpire)
+	t.Run("Remove", s.testBucketRemove)
+	t.Run("Enumerate", s.testBucketEnumerate)
+}
+
+func (s *Suite) testBucketGetMissing(t *testing.T) {
+	b := s.Store.Bucket(s.name("get-missing"))
+	val, err := b.Get("missing")
+	if err != nil {
+		t.Fatalf("Get missing key: %v", err)
+	}
+	if val != nil {
+		t.Fatalf("Get missing key returns %v, expect nil", val)
+	}
+}
+
+func (s *Suite) testBucketPutGet(t *testing.T) {
+	b := s.Store.Bucket(s.name("put-get"))
+	if err := b.Put("k", &record{Key: "k", Value: 1}, jobs.Infinite); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	expectRecord(t, b, "k", 1)
+	if err := b.Put("k", &record{Key: "k", Value: 2}, jobs.Infinite); err != nil {
+		t.Fatalf("Put overwrite: %v", err)
+	}
+	val := expectRecord(t, b, "k", 2)
+	if val.TTL() != jobs.Infinite {
+		t.Errorf("TTL is %v, expect Infinite", val.TTL())
+	}
+}
+
+func (s *Suite) testBucketTTL(t *testing.T) {
+	b := s.Store.Bucket(s.name("ttl"))
+	ttl := s.ttl()
+	if err := b.Put("k", &record{Key: "k"}, ttl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	val := expectRecord(t, b, "k", 0)
+	if val.TTL() <= 0 || val.TTL() > ttl {
+		t.Errorf("TTL is %v, expect in (0, %v]", val.TTL(), ttl)
+	}
+	s.waitExpire(ttl)
+	expectMissing(t, b, "k")
+}
+
+func (s *Suite) testBucketExpire(t *testing.T) {
+	b := s.Store.Bucket(s.name("expire"))
+	ttl := s.ttl()
+	if err := b.Put("k", &record{Key: "k"}, jobs.Infinite); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := b.Expire("k", ttl); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	val := expectRecord(t, b, "k", 0)
+	if val.TTL() <= 0 || val.TTL() > ttl {
+		t.Errorf("TTL after Expire is %v, expect in (0, %v]", val.TTL(), ttl)
+	}
+	if err := b.Expire("k", jobs.NoTTL); err != nil {
+		t.Fatalf("Expire NoTTL: %v", err)
+	}
+	val = expectRecord(t, b, "k", 0)
+	if val.TTL() != jobs.NoTTL {
+		t.Errorf("TTL after persisted is %v, expect NoTTL", val.TTL())
+	}
+	if err := b.Expire("k", ttl); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	s.waitExpire(ttl)
+	expectMissing(t, b, "k")
+}
+
+func (s *Suite) testBucketRemove(t *testing.T) {
+	b := s.Store.Bucket(s.name("remove"))
+	val, err := b.Remove("k")
+	if err != nil {
+		t.Fatalf("Remove missing key: %v", err)
+	}
+	if val != nil {
+		t.Fatalf("Remove missing key returns %v, expect nil", val)
+	}
+	if err = b.Put("k", &record{Key: "k", Value: 3}, jobs.Infinite); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if val, err = b.Remove("k"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if val == nil {
+		t.Fatal("Remove doesn't return the prior value")
+	}
+	var r record
+	if err = val.Unmarshal(&r); err != nil {
+		t.Fatalf("Unmarshal removed value: %v", err)
+	}
+	if r.Key != "k" || r.Value != 3 {
+		t.Errorf("Remove returns %+v, expect {k 3}", r)
+	}
+	expectMissing(t, b, "k")
+}
+
+func (s *Suite) testBucketEnumerate(t *testing.T) {
+	b := s.Store.Bucket(s.name("enumerate"))
+	const count = 23
+	partition := jobs.Partition("key0")
+	keys := make(map[string]bool)
+	others := 0
+	for i := 0; len(keys) < count; i++ {
+		key := "key" + strconv.Itoa(i)
+		inPartition := jobs.Partition(key) == partition
+		if !inPartition {
+			// only put a few keys outside the partition
+			if others >= 8 {
+				continue
+			}
+			others++
+		}
+		if err := b.Put(key, &record{Key: key}, jobs.Infinite); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if inPartition {
+			keys[key] = true
+		}
+	}
+
+	e := b.Enumerate(jobs.EnumOptions{PageSize: 5, Partition: partition})
+	found := make(map[string]bool)
+	for {
+		vals, err := e.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if vals == nil {
+			break
+		}
+		for _, val := range vals {
+			var r record
+			if err = val.Unmarshal(&r); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !keys[r.Key] {
+				t.Errorf("unexpected key %s in partition %d", r.Key, partition)
+			}
+			if found[r.Key] {
+				t.Errorf("duplicated key %s", r.Key)
+			}
+			found[r.Key] = true
+		}
+	}
+	if len(found) != len(keys) {
+		t.Errorf("enumerated %d keys, expect %d", len(found), len(keys))
+	}
+}
+
+func expectRecord(t *testing.T, b jobs.PartitionedStore, key string, value int) jobs.Value {
+	val, err := b.Get(key)
+	if err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	if val == nil {
+		t.Fatalf("Get %s returns nil", key)
+	}
+	var r record
+	if err = val.Unmarshal(&r); err != nil {
+		t.Fatalf("Unmarshal %s: %v", key, err)
+	}
+	if r.Key != key || r.Value != value {
+		t.Fatalf("Get %s returns %+v, expect {%s %d}", key, r, key, value)
+	}
+	return val
+}
+
+func expectMissing(t *testing.T, b jobs.PartitionedStore, key string) {
+	val, err := b.Get(key)
+	if err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	if val != nil {
+		t.Fatalf("Get %s returns %v, expect nil", key, val)
+	}
+}
@@ -0,0 +1,69 @@
+// Package storetest provides the conformance tests for jobs.Store
+// implementations.
+//
+// A store implementation runs the whole suite from its own test:
+//
+//	func TestConformance(t *testing.T) {
+//	    storetest.Run(t, NewStore(...))
+//	}
+package storetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// DefaultTTL is the default short TTL used by expiration tests
+const DefaultTTL = 2 * time.Second
+
+// Suite runs the conformance tests against a jobs.Store
+type Suite struct {
+	// Store is the store to be tested
+	Store jobs.Store
+	// TTL is the short TTL used by expiration tests, stores with
+	// coarse TTL granularity (e.g. seconds) should use larger values
+	TTL time.Duration
+
+	prefix string
+}
+
+// Run runs the full suite with default settings
+func Run(t *testing.T, store jobs.Store) {
+	(&Suite{Store: store}).Run(t)
+}
+
+// Run runs all the tests in the suite
+func (s *Suite) Run(t *testing.T) {
+	t.Run("PartitionedStore", s.TestPartitionedStore)
+	t.Run("KeySet", s.TestKeySet)
+	t.Run("OrderedList", s.TestOrderedList)
+	t.Run("TimeIndex", s.TestTimeIndex)
+	t.Run("Acquisition", s.TestAcquisition)
+	t.Run("TokenBucket", s.TestTokenBucket)
+	t.Run("Notifier", s.TestNotifier)
+	t.Run("Batch", s.TestBatch)
+}
+
+// name generates a name unique to this run so persistent stores
+// don't observe data left over by previous runs
+func (s *Suite) name(kind string) string {
+	if s.prefix == "" {
+		s.prefix = fmt.Sprintf("storetest-%d", time.Now().UnixNano())
+	}
+	return s.prefix + "-" + kind
+}
+
+func (s *Suite) ttl() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return DefaultTTL
+}
+
+// waitExpire sleeps until items with ttl are expected to expire
+func (s *Suite) waitExpire(ttl time.Duration) {
+	time.Sleep(ttl + ttl/2)
+}
@@ -0,0 +1,74 @@
+package storetest
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// TestTimeIndex verifies the contract of jobs.TimeIndex
+func (s *Suite) TestTimeIndex(t *testing.T) {
+	x := s.Store.TimeIndex(s.name("time-index"))
+	base := time.Now().Truncate(time.Second)
+	expectDue(t, x, base.Add(time.Hour), nil)
+
+	const count = 11
+	var ids []string
+	for i := 0; i < count; i++ {
+		id := "id" + strconv.Itoa(i)
+		// insert in reversed order of time
+		if err := x.Set(id, base.Add(time.Duration(count-i)*time.Minute)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		ids = append([]string{id}, ids...)
+	}
+	expectDue(t, x, base, nil)
+	expectDue(t, x, base.Add(3*time.Minute), ids[:3])
+	expectDue(t, x, base.Add(time.Hour), ids)
+
+	// update the time of existing key
+	if err := x.Set(ids[0], base.Add(time.Hour)); err != nil {
+		t.Fatalf("Set existing: %v", err)
+	}
+	expectDue(t, x, base.Add(3*time.Minute), ids[1:3])
+	expectDue(t, x, base.Add(time.Hour), append(append([]string{}, ids[1:]...), ids[0]))
+
+	if err := x.Remove(ids[1]); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := x.Remove("missing"); err != nil {
+		t.Fatalf("Remove missing key: %v", err)
+	}
+	expectDue(t, x, base.Add(3*time.Minute), ids[2:3])
+}
+
+func expectDue(t *testing.T, x jobs.TimeIndex, at time.Time, ids []string) {
+	e := x.Due(at, jobs.EnumOptions{PageSize: 4})
+	var found []string
+	for {
+		vals, err := e.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if vals == nil {
+			break
+		}
+		for _, val := range vals {
+			var id string
+			if err = val.Unmarshal(&id); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			found = append(found, id)
+		}
+	}
+	if len(found) != len(ids) {
+		t.Fatalf("due at %v: %v, expect %v", at, found, ids)
+	}
+	for i, id := range ids {
+		if found[i] != id {
+			t.Fatalf("due at %v: %v, expect %v", at, found, ids)
+		}
+	}
+}
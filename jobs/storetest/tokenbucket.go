@@ -0,0 +1,72 @@
+package storetest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// TestTokenBucket verifies the contract of jobs.TokenBucket
+func (s *Suite) TestTokenBucket(t *testing.T) {
+	b := s.Store.TokenBucket(s.name("token-bucket"))
+	limit := jobs.RateLimit{Rate: 2, Burst: 3}
+	expectTake(t, b, limit, true, true, true, false)
+	// a returned token is taken again
+	if err := b.Return(limit); err != nil {
+		t.Fatalf("Return: %v", err)
+	}
+	expectTake(t, b, limit, true, false)
+	// 1.2 tokens refilled
+	time.Sleep(600 * time.Millisecond)
+	expectTake(t, b, limit, true, false)
+
+	// returning never exceeds the burst
+	full := s.Store.TokenBucket(s.name("token-bucket-full"))
+	if err := full.Return(limit); err != nil {
+		t.Fatalf("Return: %v", err)
+	}
+	expectTake(t, full, limit, true, true, true, false)
+
+	unlimited := s.Store.TokenBucket(s.name("token-bucket-unlimited"))
+	expectTake(t, unlimited, jobs.RateLimit{}, true, true, true)
+
+	// concurrent takers never exceed the burst
+	b = s.Store.TokenBucket(s.name("token-bucket-concurrent"))
+	limit = jobs.RateLimit{Rate: 0.1, Burst: 5}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	taken := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := b.Take(limit)
+			if err != nil {
+				t.Errorf("Take: %v", err)
+			}
+			if ok {
+				lock.Lock()
+				taken++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if taken != limit.Burst {
+		t.Errorf("concurrent Take: expect %d taken, got %d", limit.Burst, taken)
+	}
+}
+
+func expectTake(t *testing.T, b jobs.TokenBucket, limit jobs.RateLimit, expected ...bool) {
+	for i, expect := range expected {
+		ok, err := b.Take(limit)
+		if err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+		if ok != expect {
+			t.Fatalf("Take #%d: expect %v, got %v", i, expect, ok)
+		}
+	}
+}
@@ -0,0 +1,608 @@
+package simple
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+func waitJob(t *testing.T, d *jobs.Dispatcher, id string) jobs.Job {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	job, err := d.WaitJob(ctx, id)
+	if err != nil {
+		t.Fatalf("wait job %s: %v", id, err)
+	}
+	return job
+}
+
+func TestJobWithSubTasks(t *testing.T) {
+	_, d := newTestDispatcher()
+	d.NewTaskExec("parent").Entry(func(ctx jobs.Context) error {
+		for i, color := range []string{"red", "blue", "green"} {
+			if _, err := ctx.NewTask("child").SetKey(fmt.Sprint(i)).With(color).Submit(); err != nil {
+				return ctx.Fail(err)
+			}
+		}
+		return ctx.ResumeTo("collect")
+	}).Stage("collect", func(ctx jobs.Context) error {
+		subs, err := ctx.SubTasks()
+		if err != nil {
+			return ctx.Fail(err)
+		}
+		var out []string
+		for _, sub := range subs {
+			var s string
+			sub.GetOutput(&s)
+			out = append(out, s)
+		}
+		return ctx.SetOutput(out)
+	}).NewTaskExec("child").Entry(func(ctx jobs.Context) error {
+		var color string
+		ctx.GetParams(&color)
+		return ctx.SetOutput(color + ":done")
+	}).Commit()
+	d.HouseKeepInterval = 50 * time.Millisecond
+	d.Worker("w1")
+	d.Worker("w2")
+	d.Watcher("h")
+	d.Start()
+	defer d.Stop()
+
+	job, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("parent"))).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := waitJob(t, d, job.ID)
+	var out []string
+	if done.State != jobs.JobSucceeded || done.GetOutput(&out) != nil || len(out) != 3 || out[0] != "red:done" {
+		t.Fatalf("unexpected job %+v, output %v", done, out)
+	}
+	if done.Progress.Total != 4 || done.Progress.Succeeded != 4 {
+		t.Fatalf("unexpected progress %+v", done.Progress)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	_, d := newTestDispatcher()
+	var runs []time.Time
+	d.NewTaskExec("t").Entry(func(ctx jobs.Context) error {
+		runs = append(runs, time.Now())
+		if len(runs) < 3 {
+			return ctx.FailRetry(errors.New("flaky"))
+		}
+		return nil
+	}).Retry(jobs.FixedBackoff(2, 300*time.Millisecond)).Commit()
+	d.Worker("w")
+	d.Start()
+	defer d.Stop()
+
+	job, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t"))).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := waitJob(t, d, job.ID)
+	if done.State != jobs.JobSucceeded || done.Task.Retries != 2 || len(runs) != 3 {
+		t.Fatalf("unexpected job %+v, runs %d", done, len(runs))
+	}
+	for i := 1; i < len(runs); i++ {
+		if runs[i].Sub(runs[i-1]) < 300*time.Millisecond {
+			t.Fatalf("backoff not honored: %v", runs)
+		}
+	}
+}
+
+func TestDelayedJob(t *testing.T) {
+	_, d := newTestDispatcher()
+	var ranAt time.Time
+	d.NewTaskExec("t").Entry(func(ctx jobs.Context) error {
+		ranAt = time.Now()
+		return nil
+	}).Commit()
+	d.Worker("w")
+	d.Start()
+	defer d.Stop()
+
+	start := time.Now()
+	job, err := d.NewJob().Delay(500 * time.Millisecond).SetTask(buildTask(t, jobs.NewTask("t"))).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done := waitJob(t, d, job.ID); done.State != jobs.JobSucceeded {
+		t.Fatalf("unexpected job %+v", done)
+	}
+	if ranAt.Sub(start) < 500*time.Millisecond {
+		t.Fatalf("ran after %v", ranAt.Sub(start))
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	_, d := newTestDispatcher()
+	d.HouseKeepInterval = 50 * time.Millisecond
+	started := make(chan struct{}, 1)
+	d.NewTaskExec("t").Entry(func(ctx jobs.Context) error {
+		if ctx.IsRollback() {
+			return nil
+		}
+		started <- struct{}{}
+		<-ctx.Context().Done()
+		return nil
+	}).Commit()
+	d.Worker("w")
+	d.Watcher("h")
+	d.Start()
+	defer d.Stop()
+
+	job, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t"))).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task not started")
+	}
+	if err = d.CancelJob(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if done := waitJob(t, d, job.ID); done.State != jobs.JobCancelled || done.Task.Result != jobs.TaskAborted {
+		t.Fatalf("unexpected job %+v", done)
+	}
+}
+
+func TestCancelJobNotStarted(t *testing.T) {
+	for name, b := range map[string]*jobs.TaskBuilder{
+		"delayed":    jobs.NewTask("t").Delay(time.Hour),
+		"unserved":   jobs.NewTask("t").SetQueue("nobody"),
+		"unexecuted": jobs.NewTask("unknown"),
+	} {
+		_, d := newTestDispatcher()
+		d.HouseKeepInterval = 50 * time.Millisecond
+		d.NewTaskExec("t").Entry(func(ctx jobs.Context) error { return nil }).Commit()
+		d.Worker("w")
+		d.Watcher("h")
+		d.Start()
+
+		job, err := d.NewJob().SetTask(buildTask(t, b)).Submit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = d.CancelJob(job.ID); err != nil {
+			t.Fatal(err)
+		}
+		done := waitJob(t, d, job.ID)
+		d.Stop()
+		if done.State != jobs.JobCancelled || done.Task.Result != jobs.TaskAborted || done.Task.Revert {
+			t.Fatalf("%s: unexpected job %+v, task %+v", name, done, done.Task)
+		}
+	}
+}
+
+func TestFailedJob(t *testing.T) {
+	_, d := newTestDispatcher()
+	d.NewTaskExec("t").Entry(func(ctx jobs.Context) error {
+		if ctx.IsRollback() {
+			return nil
+		}
+		return ctx.Fail(errors.New("boom"))
+	}).Commit()
+	d.Worker("w")
+	d.Start()
+	defer d.Stop()
+
+	job, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t"))).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := waitJob(t, d, job.ID)
+	if done.State != jobs.JobFailed || !done.Task.Revert || done.Task.Result != jobs.TaskAborted {
+		t.Fatalf("unexpected job %+v, task %+v", done, done.Task)
+	}
+}
+
+// orphanTask runs the entry task of a new job on a worker which dies
+// right away: it never publishes heartbeats, and the lease lapses soon
+func orphanTask(t *testing.T, s *Strategy, d *jobs.Dispatcher, maxRetries uint) string {
+	task := buildTask(t, jobs.NewTask("t"))
+	task.MaxRetries = maxRetries
+	job, err := d.NewJob().SetTask(task).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, err := s.NewWorker("dead", jobs.WorkerOptions{}).FetchTask()
+	if err != nil || handle == nil {
+		t.Fatalf("task not fetched: %v", err)
+	}
+	running := *handle.Task()
+	running.State = jobs.TaskRunning
+	if err = handle.Update(&running); err != nil {
+		t.Fatal(err)
+	}
+	handle.(*TaskHandle).Acquisition.Refresh(100 * time.Millisecond)
+	return job.ID
+}
+
+func TestRecoverLostWorker(t *testing.T) {
+	s, d := newTestDispatcher()
+	d.HouseKeepInterval = 50 * time.Millisecond
+	d.NewTaskExec("t").Entry(func(ctx jobs.Context) error {
+		return ctx.SetOutput("ok")
+	}).Commit()
+	jobID := orphanTask(t, s, d, 1)
+	d.Worker("live")
+	d.Watcher("h")
+	d.Start()
+	defer d.Stop()
+
+	done := waitJob(t, d, jobID)
+	if done.State != jobs.JobSucceeded || done.Task.Retries != 1 || len(done.Task.Errors) != 1 {
+		t.Fatalf("unexpected job %+v, task %+v", done, done.Task)
+	}
+	if err := done.Task.Errors[0]; err.Type != jobs.TaskErrRetry || err.Message != "worker lost: dead" {
+		t.Fatalf("unexpected error %+v", err)
+	}
+}
+
+func TestRecoverLostWorkerExhausted(t *testing.T) {
+	s, d := newTestDispatcher()
+	d.HouseKeepInterval = 50 * time.Millisecond
+	runs := 0
+	d.NewTaskExec("t").Entry(func(ctx jobs.Context) error {
+		if !ctx.IsRollback() {
+			runs++
+		}
+		return nil
+	}).Commit()
+	jobID := orphanTask(t, s, d, 0)
+	d.Worker("live")
+	d.Watcher("h")
+	d.Start()
+	defer d.Stop()
+
+	done := waitJob(t, d, jobID)
+	if done.State != jobs.JobFailed || !done.Task.Revert || len(done.Task.Errors) != 1 || runs != 0 {
+		t.Fatalf("unexpected job %+v, task %+v, runs %d", done, done.Task, runs)
+	}
+}
+
+func TestStopDrainsTasks(t *testing.T) {
+	_, d := newTestDispatcher()
+	started := make(chan struct{}, 1)
+	var stageErr error
+	d.NewTaskExec("t").Entry(func(ctx jobs.Context) error {
+		started <- struct{}{}
+		time.Sleep(300 * time.Millisecond)
+		stageErr = ctx.Context().Err()
+		return ctx.SetOutput("done")
+	}).Commit()
+	d.Worker("w")
+	d.Start()
+	job, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t"))).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task not started")
+	}
+	d.Stop().Wait()
+	if stageErr != nil {
+		t.Fatalf("stage canceled on stop: %v", stageErr)
+	}
+	done, err := d.Job(job.ID)
+	var out string
+	if err != nil || done.State != jobs.JobSucceeded || done.GetOutput(&out) != nil || out != "done" {
+		t.Fatalf("unexpected job %+v, %v", done, err)
+	}
+}
+
+func TestStopHandsBackTask(t *testing.T) {
+	for name, stop := range map[string]func(ctx jobs.Context) error{
+		"error":  func(ctx jobs.Context) error { return ctx.Context().Err() },
+		"ignore": func(ctx jobs.Context) error { return nil },
+	} {
+		s, d := newTestDispatcher()
+		d.DrainTimeout = 100 * time.Millisecond
+		started := make(chan struct{}, 1)
+		d.NewTaskExec("t").Entry(func(ctx jobs.Context) error {
+			started <- struct{}{}
+			<-ctx.Context().Done()
+			return stop(ctx)
+		}).Commit()
+		d.Worker("w")
+		d.Start()
+		job, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t"))).Submit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: task not started", name)
+		}
+		d.Stop().Wait()
+		task, err := d.Task(job.Task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.State != jobs.TaskPending || task.Revert || task.Result != jobs.TaskUnknown ||
+			task.Retries != 0 || len(task.Errors) != 0 {
+			t.Fatalf("%s: unexpected task %+v", name, task)
+		}
+
+		// the task is re-run by another dispatcher
+		d = jobs.NewDispatcher(s)
+		d.NewTaskExec("t").Entry(func(ctx jobs.Context) error { return nil }).Commit()
+		d.Worker("w2")
+		d.Start()
+		done := waitJob(t, d, job.ID)
+		d.Stop()
+		if done.State != jobs.JobSucceeded || done.Task.Retries != 0 || len(done.Task.Errors) != 0 {
+			t.Fatalf("%s: unexpected job %+v, task %+v", name, done, done.Task)
+		}
+	}
+}
+
+// runSagaJob runs a parent submitting sub tasks a, b and c in three
+// stages, and c fails. rollbacks are the tasks rolled back in order.
+func runSagaJob(t *testing.T, pivot bool) (done jobs.Job, rollbacks []string) {
+	_, d := newTestDispatcher()
+	d.HouseKeepInterval = 50 * time.Millisecond
+	var lock sync.Mutex
+	rollback := func(ctx jobs.Context) error {
+		lock.Lock()
+		rollbacks = append(rollbacks, ctx.TaskID())
+		lock.Unlock()
+		return nil
+	}
+	submit := func(ctx jobs.Context, b *jobs.TaskBuilder, next string) error {
+		if _, err := b.Submit(); err != nil {
+			return ctx.Fail(err)
+		}
+		if next == "" {
+			return nil
+		}
+		return ctx.ResumeTo(next)
+	}
+	d.NewTaskExec("parent").Entry(jobs.Revertable(func(ctx jobs.Context) error {
+		return submit(ctx, ctx.NewTask("ok").SetKey("a"), "s2")
+	}, rollback)).Stage("s2", jobs.Revertable(func(ctx jobs.Context) error {
+		b := ctx.NewTask("ok").SetKey("b")
+		if pivot {
+			b.Pivot()
+		}
+		return submit(ctx, b, "s3")
+	}, rollback)).Stage("s3", jobs.Revertable(func(ctx jobs.Context) error {
+		return submit(ctx, ctx.NewTask("bad").SetKey("c"), "")
+	}, rollback)).Commit()
+	d.NewTaskExec("ok").Entry(jobs.Revertable(func(ctx jobs.Context) error {
+		return nil
+	}, rollback)).Commit()
+	d.NewTaskExec("bad").Entry(jobs.Revertable(func(ctx jobs.Context) error {
+		return ctx.Fail(errors.New("boom"))
+	}, rollback)).Commit()
+	d.ConcurrentWorker("w", 4)
+	d.Watcher("h")
+	d.Start()
+	defer d.Stop()
+
+	job, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("parent").SetID("root"))).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done = waitJob(t, d, job.ID)
+	lock.Lock()
+	defer lock.Unlock()
+	return done, append([]string(nil), rollbacks...)
+}
+
+func TestSagaRollbackOrder(t *testing.T) {
+	done, rollbacks := runSagaJob(t, false)
+	if done.State != jobs.JobFailed || done.Task.Saga == nil {
+		t.Fatalf("unexpected job %+v, task %+v", done, done.Task)
+	}
+	// reverse completion order, and the parent is the last
+	expected := []string{"root:s3:c", "root:s2:b", "root::a", "root"}
+	if fmt.Sprint(rollbacks) != fmt.Sprint(expected) {
+		t.Fatalf("expect rollbacks %v, got %v", expected, rollbacks)
+	}
+	saga := done.Task.Saga
+	if fmt.Sprint(saga.Compensated) != fmt.Sprint(expected[:3]) || saga.Pivot != "" || len(saga.Kept) != 0 {
+		t.Fatalf("unexpected saga outcome %+v", saga)
+	}
+}
+
+func TestSagaPivot(t *testing.T) {
+	done, rollbacks := runSagaJob(t, true)
+	if done.State != jobs.JobStuck || done.Task.State != jobs.TaskStucked || done.Task.Saga == nil {
+		t.Fatalf("unexpected job %+v, task %+v", done, done.Task)
+	}
+	// the steps completed before the pivot are kept
+	if fmt.Sprint(rollbacks) != "[root:s3:c]" {
+		t.Fatalf("unexpected rollbacks %v", rollbacks)
+	}
+	saga := done.Task.Saga
+	if saga.Pivot != "root:s2:b" || fmt.Sprint(saga.Kept) != "[root:s2:b root::a]" ||
+		fmt.Sprint(saga.Compensated) != "[root:s3:c]" {
+		t.Fatalf("unexpected saga outcome %+v", saga)
+	}
+}
+
+func TestStuckEscalation(t *testing.T) {
+	_, d := newTestDispatcher()
+	d.HouseKeepInterval = 50 * time.Millisecond
+	stuck := make(chan jobs.Task, 1)
+	d.OnStuck = func(task jobs.Task) { stuck <- task }
+	d.NewTaskExec("t").Entry(jobs.Revertable(func(ctx jobs.Context) error {
+		return ctx.Fail(errors.New("forward"))
+	}, func(ctx jobs.Context) error {
+		return ctx.Fail(errors.New("rollback"))
+	})).RevertRetry(jobs.FixedBackoff(2, 50*time.Millisecond)).Commit()
+	d.Worker("w")
+	d.Watcher("h")
+	d.Start()
+	defer d.Stop()
+
+	if _, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t").SetID("root"))).Submit(); err != nil {
+		t.Fatal(err)
+	}
+	var task jobs.Task
+	select {
+	case task = <-stuck:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stucked task not escalated")
+	}
+	// the forward failure and all the failed rollbacks are kept
+	if task.ID != "root" || task.State != jobs.TaskStucked || len(task.Errors) != 4 || task.RevertRetries != 2 {
+		t.Fatalf("unexpected task %+v", task)
+	}
+	for _, e := range task.Errors {
+		if e.Type != jobs.TaskErrFail {
+			t.Fatalf("unexpected errors %+v", task.Errors)
+		}
+	}
+	tasks, err := d.StuckTasks()
+	if err != nil || len(tasks) != 1 || tasks[0].ID != "root" {
+		t.Fatalf("unexpected stucked tasks %v, %v", tasks, err)
+	}
+}
+
+// runStuckSubTask runs a parent with a sub task which gets stucked on
+// the first run, resolves it and returns the job and the sub task.
+func runStuckSubTask(t *testing.T, resolve func(d *jobs.Dispatcher, id string) error) (jobs.Job, jobs.Task) {
+	_, d := newTestDispatcher()
+	d.HouseKeepInterval = 50 * time.Millisecond
+	stuck := make(chan jobs.Task, 1)
+	d.OnStuck = func(task jobs.Task) { stuck <- task }
+	var lock sync.Mutex
+	runs := 0
+	d.NewTaskExec("parent").Entry(func(ctx jobs.Context) error {
+		if _, err := ctx.NewTask("sub").SetKey("s").Submit(); err != nil {
+			return ctx.Fail(err)
+		}
+		return nil
+	}).NewTaskExec("sub").Entry(func(ctx jobs.Context) error {
+		lock.Lock()
+		runs++
+		first := runs == 1
+		lock.Unlock()
+		if first {
+			return ctx.Stuck(errors.New("broken"))
+		}
+		return ctx.SetOutput("retried")
+	}).Commit()
+	d.ConcurrentWorker("w", 2)
+	d.Watcher("h")
+	d.Start()
+	defer d.Stop()
+
+	job, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("parent").SetID("root"))).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var task jobs.Task
+	select {
+	case task = <-stuck:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stucked task not escalated")
+	}
+	if len(task.Errors) != 1 || task.Errors[0].Type != jobs.TaskErrStuck {
+		t.Fatalf("unexpected errors %+v", task.Errors)
+	}
+	if err = resolve(d, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = resolve(d, task.ID); err != jobs.ErrTaskNotStucked {
+		t.Fatalf("expect ErrTaskNotStucked, got %v", err)
+	}
+	done := waitJob(t, d, job.ID)
+	if task, err = d.Task(task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if tasks, _ := d.StuckTasks(); len(tasks) != 0 {
+		t.Fatalf("unexpected stucked tasks %v", tasks)
+	}
+	return done, task
+}
+
+func TestRetryStuckTask(t *testing.T) {
+	done, task := runStuckSubTask(t, func(d *jobs.Dispatcher, id string) error {
+		return d.RetryTask(id)
+	})
+	var out string
+	if done.State != jobs.JobSucceeded || task.Result != jobs.TaskSuccess || task.GetOutput(&out) != nil || out != "retried" {
+		t.Fatalf("unexpected job %+v, task %+v", done, task)
+	}
+}
+
+func TestSkipStuckTask(t *testing.T) {
+	done, task := runStuckSubTask(t, func(d *jobs.Dispatcher, id string) error {
+		return d.SkipTask(id)
+	})
+	if done.State != jobs.JobFailed || task.State != jobs.TaskCompleted || task.Result != jobs.TaskAborted {
+		t.Fatalf("unexpected job %+v, task %+v", done, task)
+	}
+}
+
+func TestCompleteStuckTask(t *testing.T) {
+	done, task := runStuckSubTask(t, func(d *jobs.Dispatcher, id string) error {
+		return d.CompleteTask(id, "forced")
+	})
+	var out string
+	if done.State != jobs.JobSucceeded || task.Result != jobs.TaskSuccess || task.GetOutput(&out) != nil || out != "forced" {
+		t.Fatalf("unexpected job %+v, task %+v", done, task)
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	s, _ := newTestDispatcher()
+	var lock sync.Mutex
+	running, peak := 0, 0
+	fn := func(ctx jobs.Context) error {
+		lock.Lock()
+		if running++; running > peak {
+			peak = running
+		}
+		lock.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return nil
+	}
+	// the limit is shared by the workers of different dispatchers
+	var ds []*jobs.Dispatcher
+	for i := 0; i < 2; i++ {
+		d := jobs.NewDispatcher(s)
+		d.NewTaskExec("charge").Entry(fn).MaxConcurrency(2).Commit()
+		d.ConcurrentWorker(fmt.Sprintf("w%d", i), 4)
+		d.Start()
+		defer d.Stop()
+		ds = append(ds, d)
+	}
+	var ids []string
+	for i := 0; i < 8; i++ {
+		job, err := ds[0].NewJob().SetTask(buildTask(t, jobs.NewTask("charge"))).Submit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	for _, id := range ids {
+		if done := waitJob(t, ds[0], id); done.State != jobs.JobSucceeded {
+			t.Fatalf("unexpected job %+v", done)
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if peak != 2 {
+		t.Fatalf("expect 2 tasks running at most, got %d", peak)
+	}
+}
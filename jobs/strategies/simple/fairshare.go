@@ -0,0 +1,192 @@
+package simple
+
+import (
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// shareStride is the virtual time a share of weight 1 is charged
+// for starting a task
+const shareStride = time.Second
+
+// ShareDoc is the persisted state of an active share
+type ShareDoc struct {
+	// Pass is the virtual time of the share, the share with the
+	// smallest pass starts the next task
+	Pass time.Time `json:"pass"`
+}
+
+// shareKey is the key the task shares the workers with:
+// the tenant if specified, otherwise the job
+func shareKey(doc *TaskDoc) string {
+	if doc.Tenant != "" {
+		return doc.Tenant
+	}
+	return doc.JobID
+}
+
+func (s *Strategy) shareWeight(key string) int {
+	if weight := s.ShareWeights[key]; weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// shareQueueName is the name of the pending queue of a share in the queue
+func shareQueueName(queue, key string) string {
+	return queueName(PendingQueue, queue) + "/" + key
+}
+
+func (s *Strategy) shareQueue(queue, key string) jobs.TimeIndex {
+	return s.Store.TimeIndex(shareQueueName(queue, key))
+}
+
+func (s *Strategy) shareIndex(queue string) jobs.TimeIndex {
+	return s.Store.TimeIndex(queueName(ShareIndex, queue))
+}
+
+func shareDocKey(queue, key string) string {
+	return queue + "/" + key
+}
+
+func (s *Strategy) queryShareDoc(queue, key string) (*ShareDoc, error) {
+	val, err := s.Store.Bucket(SharesBucket).Get(shareDocKey(queue, key))
+	if err != nil || val == nil {
+		return nil, err
+	}
+	doc := &ShareDoc{}
+	return doc, val.Unmarshal(doc)
+}
+
+func (s *Strategy) saveShareDoc(t *txn, queue, key string, doc *ShareDoc) {
+	t.put(SharesBucket, shareDocKey(queue, key), doc, jobs.Infinite)
+	t.setTime(queueName(ShareIndex, queue), key, doc.Pass)
+}
+
+// activateShare adds the share to the index once it has pending tasks.
+// A new share joins at the pass of the head, so it starts its first
+// task promptly without gaining credit over the active shares.
+func (s *Strategy) activateShare(t *txn, queue, key string) error {
+	doc, err := s.queryShareDoc(queue, key)
+	if err != nil || doc != nil {
+		return err
+	}
+	doc = &ShareDoc{Pass: time.Now()}
+	keys, err := s.shareIndex(queue).Due(time.Now().Add(queueHorizon), jobs.EnumOptions{PageSize: 1}).Next()
+	if err != nil {
+		return err
+	}
+	for _, val := range keys {
+		var head string
+		if val.Unmarshal(&head) != nil || head == "" {
+			continue
+		}
+		headDoc, err := s.queryShareDoc(queue, head)
+		if err != nil {
+			return err
+		}
+		if headDoc != nil && headDoc.Pass.Before(doc.Pass) {
+			doc.Pass = headDoc.Pass
+		}
+	}
+	s.saveShareDoc(t, queue, key, doc)
+	return nil
+}
+
+// advanceShare charges the share for a started task, the share with
+// a higher weight is charged less and starts tasks more often. It's
+// not atomic across workers, so fairness is approximate.
+func (s *Strategy) advanceShare(queue, key string) error {
+	doc, err := s.queryShareDoc(queue, key)
+	if err != nil || doc == nil {
+		return err
+	}
+	doc.Pass = doc.Pass.Add(shareStride / time.Duration(s.shareWeight(key)))
+	t := s.newTxn()
+	s.saveShareDoc(t, queue, key, doc)
+	return t.commit()
+}
+
+// deactivateShare removes the share without pending tasks. The pending
+// queue is checked again after the removal, and a task is saved before
+// checking the share, so a task queued meanwhile is either seen here or
+// activates the share itself.
+func (s *Strategy) deactivateShare(queue, key string) error {
+	if _, err := s.Store.Bucket(SharesBucket).Remove(shareDocKey(queue, key)); err != nil {
+		return err
+	}
+	if err := s.shareIndex(queue).Remove(key); err != nil {
+		return err
+	}
+	return s.ensureShare(queue, key)
+}
+
+// ensureShare activates the share if it has pending tasks
+func (s *Strategy) ensureShare(queue, key string) error {
+	ids, err := s.shareQueue(queue, key).Due(time.Now().Add(queueHorizon), jobs.EnumOptions{PageSize: 1}).Next()
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	t := s.newTxn()
+	if err = s.activateShare(t, queue, key); err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// fetchTasksFromShares takes tasks from the active shares in the order
+// of pass, one task from each share per round
+func (w *WorkerStrategy) fetchTasksFromShares(queue string, full map[string]bool, handles []jobs.TaskHandle, max int) ([]jobs.TaskHandle, error) {
+	s := w.Strategy
+	for len(handles) < max {
+		fetched := false
+		e := s.shareIndex(queue).Due(time.Now().Add(queueHorizon), jobs.EnumOptions{PageSize: 10})
+		for len(handles) < max {
+			keys, err := e.Next()
+			if err != nil {
+				return handles, err
+			}
+			if keys == nil {
+				break
+			}
+			for _, val := range keys {
+				var key string
+				if err := val.Unmarshal(&key); err != nil || key == "" {
+					continue
+				}
+				count := len(handles)
+				pending := s.shareQueue(queue, key).Due(time.Now().Add(queueHorizon), jobs.EnumOptions{PageSize: 10})
+				if handles, err = w.fetchTasksFrom(pending, full, handles, count+1); err != nil {
+					return handles, err
+				}
+				if len(handles) > count {
+					fetched = true
+					err = s.advanceShare(queue, key)
+				} else {
+					err = s.deactivateShareIfIdle(queue, key)
+				}
+				if err != nil {
+					return handles, err
+				}
+				if len(handles) >= max {
+					break
+				}
+			}
+		}
+		if !fetched {
+			break
+		}
+	}
+	return handles, nil
+}
+
+// deactivateShareIfIdle deactivates the share if the pending queue is
+// empty, the tasks may be deferred by limits or owned by others
+func (s *Strategy) deactivateShareIfIdle(queue, key string) error {
+	ids, err := s.shareQueue(queue, key).Due(time.Now().Add(queueHorizon), jobs.EnumOptions{PageSize: 1}).Next()
+	if err != nil || len(ids) > 0 {
+		return err
+	}
+	return s.deactivateShare(queue, key)
+}
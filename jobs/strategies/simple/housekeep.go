@@ -0,0 +1,197 @@
+package simple
+
+import (
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// HouseKeep runs house keeping logic on each waiting, running or
+// expired task, and the entry task of each canceled job. Multiple
+// watchers share the work by acquiring a house keeping lease per task,
+// the task is skipped if it's owned by another watcher. The leases are
+// owned by "housekeep:<id>", as acquisition is re-entrant and the id
+// may be shared by a worker.
+func (s *Strategy) HouseKeep(id string, logic jobs.HouseKeepLogic) (err error) {
+	worker := &WorkerStrategy{WorkerID: "housekeep:" + id, Strategy: s}
+	opts := jobs.EnumOptions{PageSize: 10}
+	if err = s.drainPendingList(opts); err != nil {
+		return
+	}
+	for _, e := range []jobs.Enumerator{
+		s.Store.OrderedList(WaitingList).Enumerate(opts),
+		s.Store.OrderedList(RunningList).Enumerate(opts),
+		s.Store.TimeIndex(ExpiringIndex).Due(time.Now(), opts),
+	} {
+		stopped, e1 := worker.houseKeepTasks(e, nil, logic)
+		if e1 != nil {
+			err = e1
+		}
+		if stopped {
+			return
+		}
+	}
+	// the entry task of a canceled job may be pending in future, or in
+	// a queue no worker serves
+	cancels := s.Store.OrderedList(CancelList).Enumerate(opts)
+	if _, e1 := worker.houseKeepTasks(cancels, s.entryTaskID, logic); e1 != nil {
+		err = e1
+	}
+	return
+}
+
+// drainPendingList moves the tasks in the deprecated PendingList to
+// the pending queues. A task updated meanwhile is left for the next
+// round.
+func (s *Strategy) drainPendingList(opts jobs.EnumOptions) error {
+	list := s.Store.OrderedList(PendingList)
+	e := list.Enumerate(opts)
+	for {
+		ids, err := e.Next()
+		if err != nil {
+			return err
+		}
+		if ids == nil {
+			return nil
+		}
+		for _, val := range ids {
+			var id string
+			if val.Unmarshal(&id) != nil || id == "" {
+				continue
+			}
+			doc, docVal, err := s.queryTaskDocValue(id)
+			if err != nil {
+				return err
+			}
+			if doc == nil || doc.State != jobs.TaskPending {
+				if err = list.Set(id, false); err != nil {
+					return err
+				}
+				continue
+			}
+			stats, err := s.queryTaskStats(id)
+			if err != nil {
+				return err
+			}
+			t := s.newTxn()
+			t.expect(TasksBucket, id, docVal)
+			if err = s.writeTask(t, doc, stats, nil); err != nil {
+				return err
+			}
+			t.setKey(PendingList, id, false)
+			if err = t.commit(); err != nil && err != jobs.ErrConflict {
+				return err
+			}
+		}
+	}
+}
+
+// entryTaskID finds the entry task of the job, empty if not found
+func (s *Strategy) entryTaskID(jobID string) (string, error) {
+	doc, err := s.queryJobDoc(jobID)
+	if err != nil || doc == nil {
+		return "", err
+	}
+	return doc.TaskID, nil
+}
+
+// houseKeepTasks runs the logic on the enumerated tasks, taskID maps
+// the enumerated ids to task ids if not nil
+func (w *WorkerStrategy) houseKeepTasks(e jobs.Enumerator, taskID func(string) (string, error), logic jobs.HouseKeepLogic) (stopped bool, err error) {
+	for {
+		ids, e1 := e.Next()
+		if e1 != nil {
+			return false, e1
+		}
+		if ids == nil {
+			break
+		}
+		for _, val := range ids {
+			var id string
+			if e1 = val.Unmarshal(&id); e1 != nil || id == "" {
+				continue
+			}
+			if taskID != nil {
+				if id, e1 = taskID(id); e1 != nil || id == "" {
+					if e1 != nil {
+						err = e1
+					}
+					continue
+				}
+			}
+			ctx, e1 := w.houseKeepContext(id)
+			if e1 != nil {
+				err = e1
+				continue
+			}
+			if ctx == nil {
+				continue
+			}
+			if e1 = logic(ctx); e1 != nil {
+				err = e1
+			}
+			ctx.acquisition.Release()
+			if ctx.stopped {
+				return true, err
+			}
+		}
+	}
+	return
+}
+
+// HouseKeepContext implements jobs.HouseKeepContext
+type HouseKeepContext struct {
+	WorkerStrategy *WorkerStrategy
+	CachedTask     *jobs.Task
+
+	acquisition jobs.Acquisition
+	job         *jobs.Job
+	stopped     bool
+}
+
+// houseKeepContext acquires the house keeping lease of the task,
+// nil is returned if the task is owned by another watcher
+func (w *WorkerStrategy) houseKeepContext(taskID string) (*HouseKeepContext, error) {
+	acq, err := w.Strategy.Store.Acquire("housekeep:"+taskID, w.WorkerID)
+	if err != nil || !acq.Acquired() {
+		return nil, err
+	}
+	task, err := w.Strategy.QueryTask(taskID)
+	if err != nil || task == nil {
+		acq.Release()
+		return nil, err
+	}
+	return &HouseKeepContext{
+		WorkerStrategy: w,
+		CachedTask:     task,
+		acquisition:    acq,
+	}, nil
+}
+
+// Job implements HouseKeepContext
+func (c *HouseKeepContext) Job() *jobs.Job {
+	if c.job == nil {
+		c.job, _ = c.WorkerStrategy.Strategy.QueryJob(c.CachedTask.JobID)
+	}
+	return c.job
+}
+
+// Task implements HouseKeepContext
+func (c *HouseKeepContext) Task() *jobs.Task {
+	return c.CachedTask
+}
+
+// Acquire implements HouseKeepContext
+func (c *HouseKeepContext) Acquire(taskID string) (jobs.TaskHandle, error) {
+	return c.WorkerStrategy.acquireTask(taskID)
+}
+
+// Worker implements HouseKeepContext
+func (c *HouseKeepContext) Worker(id string) (*jobs.WorkerInfo, error) {
+	return c.WorkerStrategy.Strategy.queryWorker(id)
+}
+
+// Stop implements HouseKeepContext
+func (c *HouseKeepContext) Stop() {
+	c.stopped = true
+}
@@ -0,0 +1,218 @@
+package simple
+
+import (
+	"sync"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// scheduledPeekLimit is the max number of scheduled tasks checked for
+// the next one the worker accepts
+const scheduledPeekLimit = 100
+
+// notifyPending wakes up the workers of the queue if supported by the
+// store, it's best effort as workers still poll
+func (s *Strategy) notifyPending(queue string) {
+	if notifier, ok := s.Store.(jobs.Notifier); ok {
+		notifier.Notify(queueName(PendingChannel, queue))
+	}
+}
+
+// notifyScheduled lets the workers of the queue re-arm the timers
+// for the scheduled tasks
+func (s *Strategy) notifyScheduled(queue string) {
+	if notifier, ok := s.Store.(jobs.Notifier); ok {
+		notifier.Notify(queueName(ScheduledChannel, queue))
+	}
+}
+
+// WatchTasks implements jobs.TaskWatcher, the worker is notified when
+// tasks of its queues become pending, when a scheduled task it accepts
+// becomes due, and when the tasks deferred by rate limits may start.
+// Nothing is polled, the timers are re-armed on writes. The watch ends
+// if the subscription of any queue ends.
+func (w *WorkerStrategy) WatchTasks(stopCh jobs.StopChan) (<-chan struct{}, error) {
+	notifier, ok := w.Strategy.Store.(jobs.Notifier)
+	if !ok {
+		return nil, nil
+	}
+	queues := w.Options.Queues
+	if len(queues) == 0 {
+		queues = []string{""}
+	}
+
+	subStopCh := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() { close(subStopCh) })
+	}
+	var pendingSubs, scheduledSubs []<-chan struct{}
+	for _, queue := range queues {
+		pending, err := notifier.Subscribe(queueName(PendingChannel, queue), subStopCh)
+		if err == nil {
+			pendingSubs = append(pendingSubs, pending)
+			var scheduled <-chan struct{}
+			scheduled, err = notifier.Subscribe(queueName(ScheduledChannel, queue), subStopCh)
+			scheduledSubs = append(scheduledSubs, scheduled)
+		}
+		if err != nil {
+			stop()
+			return nil, err
+		}
+	}
+
+	notifyCh := make(chan struct{}, 1)
+	rearmCh := make(chan struct{}, 1)
+	w.lock.Lock()
+	w.rearmCh = rearmCh
+	w.lock.Unlock()
+
+	var wg sync.WaitGroup
+	forward := func(ch <-chan struct{}, to chan struct{}) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer stop()
+			for {
+				select {
+				case _, ok := <-ch:
+					if !ok {
+						return
+					}
+					signal(to)
+				case <-stopCh:
+					return
+				}
+			}
+		}()
+	}
+	for _, ch := range pendingSubs {
+		forward(ch, notifyCh)
+	}
+	for _, ch := range scheduledSubs {
+		forward(ch, rearmCh)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.wakeOnReady(queues, notifyCh, rearmCh, stopCh, subStopCh)
+	}()
+	go func() {
+		wg.Wait()
+		close(notifyCh)
+	}()
+	return notifyCh, nil
+}
+
+// wakeOnReady notifies when the next scheduled task or the deferred
+// tasks are ready, the timer is re-armed when tasks are scheduled or
+// deferred
+func (w *WorkerStrategy) wakeOnReady(queues []string, notifyCh, rearmCh chan struct{}, stopCh jobs.StopChan, subStopCh chan struct{}) {
+	// the tasks ready before are not notified again, they are
+	// fetched, or left for busy workers to fetch later
+	var notified time.Time
+	for {
+		var timer *time.Timer
+		var timerCh <-chan time.Time
+		if at := w.nextReadyTime(queues, notified); !at.IsZero() {
+			timer = time.NewTimer(at.Sub(time.Now()))
+			timerCh = timer.C
+		}
+		stopped := false
+		select {
+		case <-timerCh:
+			notified = time.Now()
+			signal(notifyCh)
+		case <-rearmCh:
+		case <-stopCh:
+			stopped = true
+		case <-subStopCh:
+			stopped = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// nextReadyTime is the earliest time after notified when a scheduled
+// task the worker accepts becomes due, or the deferred tasks may
+// start, zero if nothing is expected
+func (w *WorkerStrategy) nextReadyTime(queues []string, notified time.Time) (next time.Time) {
+	w.lock.Lock()
+	if w.deferredUntil.After(notified) {
+		next = w.deferredUntil
+	}
+	w.lock.Unlock()
+	for _, queue := range queues {
+		at, err := w.nextScheduledTime(queue, notified)
+		if err == nil && !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+	return
+}
+
+// nextScheduledTime finds the first task in the scheduled index of the
+// queue which is due after notified and accepted by the worker
+func (w *WorkerStrategy) nextScheduledTime(queue string, notified time.Time) (time.Time, error) {
+	s := w.Strategy
+	e := s.Store.TimeIndex(queueName(ScheduledIndex, queue)).
+		Due(time.Now().Add(queueHorizon), jobs.EnumOptions{PageSize: 10})
+	for checked := 0; checked < scheduledPeekLimit; {
+		ids, err := e.Next()
+		if err != nil || ids == nil {
+			return time.Time{}, err
+		}
+		for _, val := range ids {
+			checked++
+			var id string
+			if err = val.Unmarshal(&id); err != nil || id == "" {
+				continue
+			}
+			task, err := s.QueryTask(id)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if task == nil || task.State != jobs.TaskPending || task.Stats == nil {
+				continue
+			}
+			if w.Options.Accept != nil && !w.Options.Accept(task) {
+				continue
+			}
+			// the index is ordered by time
+			if at := task.Stats.ScheduledAt; at.After(notified) {
+				return at, nil
+			}
+		}
+	}
+	return time.Time{}, nil
+}
+
+// deferTask records a task is deferred by rate limits, and may start
+// after the duration
+func (w *WorkerStrategy) deferTask(after time.Duration) {
+	at := time.Now().Add(after)
+	w.lock.Lock()
+	if w.deferredUntil.Before(time.Now()) || at.Before(w.deferredUntil) {
+		w.deferredUntil = at
+	}
+	rearmCh := w.rearmCh
+	w.lock.Unlock()
+	if rearmCh != nil {
+		signal(rearmCh)
+	}
+}
+
+// signal sends to the buffered chan without blocking,
+// the pending signal is coalesced
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,34 @@
+package simple
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+func TestNextReadyTime(t *testing.T) {
+	s, d := newTestDispatcher()
+	at := time.Now().Add(time.Hour)
+	if _, err := d.NewJob().RunAt(at).SetTask(buildTask(t, jobs.NewTask("t"))).Submit(); err != nil {
+		t.Fatal(err)
+	}
+	accept := func(name string) func(*jobs.Task) bool {
+		return func(task *jobs.Task) bool { return task.Name == name }
+	}
+	w := s.NewWorker("w", jobs.WorkerOptions{Accept: accept("other")}).(*WorkerStrategy)
+	if next := w.nextReadyTime([]string{""}, time.Time{}); !next.IsZero() {
+		t.Fatalf("woken up for a task not accepted at %v", next)
+	}
+	w = s.NewWorker("w", jobs.WorkerOptions{Accept: accept("t")}).(*WorkerStrategy)
+	if next := w.nextReadyTime([]string{""}, time.Time{}); !next.Equal(at) {
+		t.Fatalf("expect woken up at %v, got %v", at, next)
+	}
+	if next := w.nextReadyTime([]string{""}, at); !next.IsZero() {
+		t.Fatalf("woken up again at %v", next)
+	}
+	w.deferTask(time.Minute)
+	if next := w.nextReadyTime([]string{""}, time.Time{}); !next.Before(at) {
+		t.Fatalf("expect woken up for deferred tasks, got %v", next)
+	}
+}
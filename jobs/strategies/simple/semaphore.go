@@ -0,0 +1,41 @@
+package simple
+
+import "strconv"
+
+// reserveSlot acquires one of the concurrency slots of the task name
+// if the concurrency is limited, false is returned if all slots are
+// taken. A slot is owned by the task, so a task recovered from a lost
+// worker reclaims its own slot, and the slot of a crashed worker is
+// freed when the lease expires.
+func (w *WorkerStrategy) reserveSlot(handle *TaskHandle) (bool, error) {
+	if w.Options.MaxConcurrency == nil {
+		return true, nil
+	}
+	name := handle.CachedTask.Name
+	limit := w.Options.MaxConcurrency(name)
+	if limit <= 0 {
+		return true, nil
+	}
+	for i := 0; i < limit; i++ {
+		acq, err := w.Strategy.Store.Acquire(slotName(name, i), handle.TaskID)
+		if err != nil {
+			return false, err
+		}
+		if !acq.Acquired() {
+			continue
+		}
+		if ttl := w.Strategy.LeaseTTL; ttl > 0 && ttl != acq.TTL() {
+			if err = acq.Refresh(ttl); err != nil {
+				acq.Release()
+				return false, err
+			}
+		}
+		handle.slot = acq
+		return true, nil
+	}
+	return false, nil
+}
+
+func slotName(taskName string, index int) string {
+	return "slot:" + taskName + ":" + strconv.Itoa(index)
+}
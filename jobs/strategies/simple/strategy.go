@@ -2,6 +2,7 @@ package simple
 
 import (
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/evo-cloud/cloudrt/jobs"
@@ -10,15 +11,36 @@ import (
 // Strategy implements jobs.Strategy
 type Strategy struct {
 	Store jobs.Store
+	// LeaseTTL is the TTL of task ownership, the store default is used if 0
+	LeaseTTL time.Duration
+	// PriorityAging is the time a pending task waits to gain one
+	// priority level, DefaultPriorityAging is used if 0
+	PriorityAging time.Duration
+	// FairShare shares the workers among the jobs, or the tenants if
+	// specified, instead of starting the oldest pending tasks first.
+	// Priority only orders the tasks of the same share. It must be
+	// the same for all dispatchers of the store.
+	FairShare bool
+	// ShareWeights are the weights of the shares keyed by tenant or
+	// job id, 1 if not specified
+	ShareWeights map[string]int
 }
 
+// DefaultPriorityAging is the default value of Strategy.PriorityAging
+const DefaultPriorityAging = time.Second
+
+// queueHorizon is far enough to include all queued tasks
+const queueHorizon = 100 * 365 * 24 * time.Hour
+
 // JobDoc is the persisted document of job
 type JobDoc struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	TaskID    string    `json:"task-id"`
-	CreatedAt time.Time `json:"created-at"`
-	UpdatedAt time.Time `json:"updated-at"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	TaskID    string          `json:"task-id"`
+	State     jobs.JobState   `json:"state"`
+	Output    json.RawMessage `json:"output"`
+	CreatedAt time.Time       `json:"created-at"`
+	UpdatedAt time.Time       `json:"updated-at"`
 }
 
 // NewJobDoc creates a JobDoc from a job
@@ -27,6 +49,8 @@ func NewJobDoc(job *jobs.Job) *JobDoc {
 		ID:        job.ID,
 		Name:      job.Name,
 		TaskID:    job.Task.ID,
+		State:     job.State,
+		Output:    json.RawMessage(job.Output),
 		CreatedAt: job.CreatedAt,
 		UpdatedAt: job.UpdatedAt,
 	}
@@ -37,6 +61,8 @@ func (d *JobDoc) ToJob() *jobs.Job {
 	return &jobs.Job{
 		ID:        d.ID,
 		Name:      d.Name,
+		State:     d.State,
+		Output:    []byte(d.Output),
 		CreatedAt: d.CreatedAt,
 		UpdatedAt: d.UpdatedAt,
 	}
@@ -44,98 +70,159 @@ func (d *JobDoc) ToJob() *jobs.Job {
 
 // TaskDoc is the persisted document of task
 type TaskDoc struct {
-	ID         string           `json:"id"`          // globally unique task id
-	ParentID   string           `json:"parent-id"`   // parent task id
-	JobID      string           `json:"job-id"`      // job id
-	Name       string           `json:"name"`        // task name
-	Params     json.RawMessage  `json:"params"`      // encoded parameters
-	State      jobs.TaskState   `json:"state"`       // current state
-	Result     jobs.TaskResult  `json:"result"`      // result when task completes
-	Revert     bool             `json:"revert"`      // in rollback direction
-	Retries    uint             `json:"retries"`     // current retry number
-	MaxRetries uint             `json:"max-retries"` // max count of retries
-	Stage      string           `json:"stage"`       // current stage
-	ResumeTo   string           `json:"resume-to"`   // next stage resume to
-	Data       json.RawMessage  `json:"data"`        // task specific data
-	Output     json.RawMessage  `json:"output"`      // output when completed
-	Errors     []jobs.TaskError `json:"errors"`      // errors happened
-	CreatedAt  time.Time        `json:"created-at"`  // task creation time
-	UpdatedAt  time.Time        `json:"updated-at"`  // last modification time
-	SubTaskIDs []string         `json:"subtask-ids"` // subtask ID list
+	ID            string            `json:"id"`             // globally unique task id
+	ParentID      string            `json:"parent-id"`      // parent task id
+	JobID         string            `json:"job-id"`         // job id
+	Name          string            `json:"name"`           // task name
+	Priority      int               `json:"priority"`       // higher goes first
+	Queue         string            `json:"queue"`          // served by workers of the queue
+	JobRateLimit  *jobs.RateLimit   `json:"job-rate-limit"` // rate limit of the tasks in the job
+	Tenant        string            `json:"tenant"`         // tenant owning the job
+	Params        json.RawMessage   `json:"params"`         // encoded parameters
+	State         jobs.TaskState    `json:"state"`          // current state
+	Result        jobs.TaskResult   `json:"result"`         // result when task completes
+	Revert        bool              `json:"revert"`         // in rollback direction
+	Compensation  jobs.Compensation `json:"compensation"`   // compensable or pivot
+	Saga          *jobs.SagaOutcome `json:"saga,omitempty"` // compensation of sub tasks
+	Retries       uint              `json:"retries"`        // current retry number
+	MaxRetries    uint              `json:"max-retries"`    // max count of retries
+	RevertRetries uint              `json:"revert-retries"` // retry number in rollback
+	RetryPolicy   *jobs.RetryPolicy `json:"retry-policy"`   // overrides TaskExec's policy
+	RevertPolicy  *jobs.RetryPolicy `json:"revert-policy"`  // retry policy of rollback
+	Stage         string            `json:"stage"`          // current stage
+	ResumeTo      string            `json:"resume-to"`      // next stage resume to
+	Data          json.RawMessage   `json:"data"`           // task specific data
+	Output        json.RawMessage   `json:"output"`         // output when completed
+	Errors        []jobs.TaskError  `json:"errors"`         // errors happened
+	CreatedAt     time.Time         `json:"created-at"`     // task creation time
+	UpdatedAt     time.Time         `json:"updated-at"`     // last modification time
+	CompletedAt   time.Time         `json:"completed-at"`   // first completion time
+	Revision      uint64            `json:"revision"`       // incremented on each save
+	SubTaskIDs    []string          `json:"subtask-ids"`    // subtask ID list
 }
 
 // NewTaskDoc creates a TaskDoc from a Task
 func NewTaskDoc(task *jobs.Task) *TaskDoc {
 	return &TaskDoc{
-		ID:         task.ID,
-		ParentID:   task.ParentID,
-		JobID:      task.JobID,
-		Name:       task.Name,
-		Params:     json.RawMessage(task.Params),
-		State:      task.State,
-		Result:     task.Result,
-		Revert:     task.Revert,
-		Retries:    task.Retries,
-		MaxRetries: task.MaxRetries,
-		Stage:      task.Stage,
-		ResumeTo:   task.ResumeTo,
-		Data:       json.RawMessage(task.Data),
-		Output:     json.RawMessage(task.Output),
-		Errors:     task.Errors,
-		CreatedAt:  task.CreatedAt,
-		UpdatedAt:  task.UpdatedAt,
-		SubTaskIDs: task.SubTaskIDs,
+		ID:            task.ID,
+		ParentID:      task.ParentID,
+		JobID:         task.JobID,
+		Name:          task.Name,
+		Priority:      task.Priority,
+		Queue:         task.Queue,
+		JobRateLimit:  task.JobRateLimit,
+		Tenant:        task.Tenant,
+		Params:        json.RawMessage(task.Params),
+		State:         task.State,
+		Result:        task.Result,
+		Revert:        task.Revert,
+		Compensation:  task.Compensation,
+		Saga:          task.Saga,
+		Retries:       task.Retries,
+		MaxRetries:    task.MaxRetries,
+		RevertRetries: task.RevertRetries,
+		RetryPolicy:   task.RetryPolicy,
+		RevertPolicy:  task.RevertPolicy,
+		Stage:         task.Stage,
+		ResumeTo:      task.ResumeTo,
+		Data:          json.RawMessage(task.Data),
+		Output:        json.RawMessage(task.Output),
+		Errors:        task.Errors,
+		CreatedAt:     task.CreatedAt,
+		UpdatedAt:     task.UpdatedAt,
+		CompletedAt:   task.CompletedAt,
+		Revision:      task.Revision,
+		SubTaskIDs:    task.SubTaskIDs,
 	}
 }
 
 // ToTask converts TaskDoc to Task
 func (d *TaskDoc) ToTask() *jobs.Task {
 	return &jobs.Task{
-		ID:         d.ID,
-		ParentID:   d.ParentID,
-		JobID:      d.JobID,
-		Name:       d.Name,
-		Params:     []byte(d.Params),
-		State:      d.State,
-		Result:     d.Result,
-		Revert:     d.Revert,
-		Retries:    d.Retries,
-		MaxRetries: d.MaxRetries,
-		Stage:      d.Stage,
-		ResumeTo:   d.ResumeTo,
-		Data:       []byte(d.Data),
-		Output:     []byte(d.Output),
-		Errors:     d.Errors,
-		CreatedAt:  d.CreatedAt,
-		UpdatedAt:  d.UpdatedAt,
-		SubTaskIDs: d.SubTaskIDs,
+		ID:            d.ID,
+		ParentID:      d.ParentID,
+		JobID:         d.JobID,
+		Name:          d.Name,
+		Priority:      d.Priority,
+		Queue:         d.Queue,
+		JobRateLimit:  d.JobRateLimit,
+		Tenant:        d.Tenant,
+		Params:        []byte(d.Params),
+		State:         d.State,
+		Result:        d.Result,
+		Revert:        d.Revert,
+		Compensation:  d.Compensation,
+		Saga:          d.Saga,
+		Retries:       d.Retries,
+		MaxRetries:    d.MaxRetries,
+		RevertRetries: d.RevertRetries,
+		RetryPolicy:   d.RetryPolicy,
+		RevertPolicy:  d.RevertPolicy,
+		Stage:         d.Stage,
+		ResumeTo:      d.ResumeTo,
+		Data:          []byte(d.Data),
+		Output:        []byte(d.Output),
+		Errors:        d.Errors,
+		CreatedAt:     d.CreatedAt,
+		UpdatedAt:     d.UpdatedAt,
+		CompletedAt:   d.CompletedAt,
+		Revision:      d.Revision,
+		SubTaskIDs:    d.SubTaskIDs,
 	}
 }
 
 // Names
 const (
-	JobsBucket      = "jobs"
-	TasksBucket     = "tasks"
-	TaskStatsBucket = "task-stats"
-	CancelList      = "job-cancellation"
-	PendingList     = "task-pending"
-	WaitingList     = "task-waiting"
+	JobsBucket       = "jobs"
+	TasksBucket      = "tasks"
+	TaskStatsBucket  = "task-stats"
+	WorkersBucket    = "workers"
+	CancelList       = "job-cancellation"
+	PendingQueue     = "task-queue"
+	WaitingList      = "task-waiting"
+	RunningList      = "task-running"
+	StuckList        = "task-stuck"
+	ShareIndex       = "task-shares"
+	SharesBucket     = "task-shares"
+	PendingChannel   = "task-pending-events"
+	ScheduledChannel = "task-scheduling"
+	ScheduledIndex   = "task-scheduled"
+	ExpiringIndex    = "task-expiring"
+
+	// PendingList is the list of pending tasks before they are queued
+	// by priority in PendingQueue.
+	//
+	// Deprecated: it's only drained by HouseKeep, moving the tasks
+	// left by earlier versions to PendingQueue.
+	PendingList = "task-pending"
 )
 
 // SubmitJob implements Strategy
-func (s *Strategy) SubmitJob(job *jobs.Job) (err error) {
+func (s *Strategy) SubmitJob(job *jobs.Job) error {
+	t := s.newTxn()
+	// the job is saved with the state of the entry task
 	doc := NewJobDoc(job)
-	err = s.Store.Bucket(JobsBucket).Put(doc.ID, doc, jobs.Infinite)
-	if err != nil {
-		return
-	}
 	taskDoc := NewTaskDoc(job.Task)
 	taskDoc.State = jobs.TaskPending
-	return s.saveTask(taskDoc, nil)
+	if err := s.writeTask(t, taskDoc, job.Task.Stats, doc); err != nil {
+		return err
+	}
+	return t.commit()
 }
 
-// CancelJob implements Strategy
+// CancelJob implements Strategy, the cancellation marker is removed
+// when the job reaches a final state
 func (s *Strategy) CancelJob(id string) error {
+	job, err := s.queryJobDoc(id)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return jobs.NotExist(id)
+	}
+	if job.State.IsFinal() {
+		return nil
+	}
 	return s.Store.OrderedList(CancelList).Set(id, true)
 }
 
@@ -147,39 +234,136 @@ func (s *Strategy) IsJobCanceling(id string) (bool, error) {
 // QueryJob implements Strategy
 func (s *Strategy) QueryJob(id string) (*jobs.Job, error) {
 	doc, err := s.queryJobDoc(id)
-	if err != nil {
+	if err != nil || doc == nil {
 		return nil, err
 	}
 	job := doc.ToJob()
-	job.Task, err = s.QueryTask(doc.TaskID)
+	if job.Task, err = s.QueryTask(doc.TaskID); err != nil {
+		return nil, err
+	}
+	job.Progress, err = s.jobProgress(doc.TaskID)
 	return job, err
 }
 
+// QueryJobState implements Strategy, the state is derived from the
+// entry task
+func (s *Strategy) QueryJobState(id string) (jobs.JobState, error) {
+	doc, err := s.queryJobDoc(id)
+	if err != nil {
+		return jobs.JobPending, err
+	}
+	if doc == nil {
+		return jobs.JobPending, jobs.NotExist(id)
+	}
+	task, err := s.QueryTask(doc.TaskID)
+	if err != nil || task == nil {
+		return doc.State, err
+	}
+	canceling, err := s.cancelRequested(id)
+	if err != nil {
+		return doc.State, err
+	}
+	return jobs.JobStateOf(task, canceling), nil
+}
+
 // QueryTask implements Strategy
 func (s *Strategy) QueryTask(id string) (*jobs.Task, error) {
-	doc, err := s.queryTaskDoc(id)
-	if err != nil {
-		return nil, err
+	task, _, err := s.queryTask(id)
+	return task, err
+}
+
+// queryTask also returns the stored value of the task doc
+func (s *Strategy) queryTask(id string) (*jobs.Task, jobs.Value, error) {
+	doc, val, err := s.queryTaskDocValue(id)
+	if err != nil || doc == nil {
+		return nil, nil, err
 	}
 	stats, err := s.queryTaskStats(id)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	task := doc.ToTask()
 	if stats != nil {
 		task.Stats = stats
 	}
-	return task, nil
+	return task, val, nil
+}
+
+// QueryStuckTasks implements Strategy
+func (s *Strategy) QueryStuckTasks() ([]*jobs.Task, error) {
+	var tasks []*jobs.Task
+	e := s.Store.OrderedList(StuckList).Enumerate(jobs.EnumOptions{PageSize: 10})
+	for {
+		ids, err := e.Next()
+		if err != nil {
+			return nil, err
+		}
+		if ids == nil {
+			break
+		}
+		for _, val := range ids {
+			var taskID string
+			if err = val.Unmarshal(&taskID); err != nil || taskID == "" {
+				continue
+			}
+			task, err := s.QueryTask(taskID)
+			if err != nil {
+				return nil, err
+			}
+			if task != nil && task.State == jobs.TaskStucked {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	return tasks, nil
 }
 
 // NewWorker creates a worker strategy
-func (s *Strategy) NewWorker(id string) jobs.WorkerStrategy {
-	return &WorkerStrategy{WorkerID: id, Strategy: s}
+func (s *Strategy) NewWorker(id string, opts jobs.WorkerOptions) jobs.WorkerStrategy {
+	return &WorkerStrategy{WorkerID: id, Strategy: s, Options: opts}
 }
 
-// HouseKeep runs house keeping logic
-func (s *Strategy) HouseKeep(id string, logic jobs.HouseKeepLogic) error {
-	// TODO
+// jobProgress walks the task tree from the entry task
+func (s *Strategy) jobProgress(taskID string) (*jobs.JobProgress, error) {
+	progress := &jobs.JobProgress{}
+	ids := []string{taskID}
+	for len(ids) > 0 {
+		doc, err := s.queryTaskDoc(ids[0])
+		if err != nil {
+			return nil, err
+		}
+		ids = ids[1:]
+		if doc == nil {
+			continue
+		}
+		progress.Count(doc.ToTask())
+		ids = append(ids, doc.SubTaskIDs...)
+	}
+	return progress, nil
+}
+
+// updateJob keeps the job state in sync with the entry task,
+// job is the one being submitted, or nil to query
+func (s *Strategy) updateJob(t *txn, doc *TaskDoc, job *JobDoc) (err error) {
+	if job == nil {
+		if job, err = s.queryJobDoc(doc.JobID); err != nil {
+			return err
+		}
+	}
+	if job == nil || job.TaskID != doc.ID {
+		return nil
+	}
+	canceling, err := s.cancelRequested(job.ID)
+	if err != nil {
+		return err
+	}
+	job.State = jobs.JobStateOf(doc.ToTask(), canceling)
+	job.Output = doc.Output
+	job.UpdatedAt = doc.UpdatedAt
+	t.put(JobsBucket, job.ID, job, jobs.Infinite)
+	if canceling && job.State.IsFinal() {
+		t.setKey(CancelList, job.ID, false)
+	}
 	return nil
 }
 
@@ -193,12 +377,19 @@ func (s *Strategy) queryJobDoc(id string) (*JobDoc, error) {
 }
 
 func (s *Strategy) queryTaskDoc(id string) (*TaskDoc, error) {
+	doc, _, err := s.queryTaskDocValue(id)
+	return doc, err
+}
+
+// queryTaskDocValue also returns the stored value for detecting
+// lost updates when the task is saved
+func (s *Strategy) queryTaskDocValue(id string) (*TaskDoc, jobs.Value, error) {
 	val, err := s.Store.Bucket(TasksBucket).Get(id)
 	if err != nil || val == nil {
-		return nil, err
+		return nil, nil, err
 	}
 	doc := &TaskDoc{}
-	return doc, val.Unmarshal(doc)
+	return doc, val, val.Unmarshal(doc)
 }
 
 func (s *Strategy) queryTaskStats(id string) (*jobs.TaskStats, error) {
@@ -210,19 +401,96 @@ func (s *Strategy) queryTaskStats(id string) (*jobs.TaskStats, error) {
 	return stats, val.Unmarshal(stats)
 }
 
+func (s *Strategy) queryWorker(id string) (*jobs.WorkerInfo, error) {
+	val, err := s.Store.Bucket(WorkersBucket).Get(id)
+	if err != nil || val == nil {
+		return nil, err
+	}
+	info := &jobs.WorkerInfo{}
+	return info, val.Unmarshal(info)
+}
+
 func (s *Strategy) cancelRequested(id string) (bool, error) {
 	return s.Store.OrderedList(CancelList).Has(id)
 }
 
-func (s *Strategy) saveTask(doc *TaskDoc, stats *jobs.TaskStats) (err error) {
+// queueTime orders the pending tasks: a task with higher priority is
+// queued as if it was pending earlier, and a task waiting longer than
+// the aging of the priority difference goes first, so no starvation
+func (s *Strategy) queueTime(doc *TaskDoc) time.Time {
+	aging := s.PriorityAging
+	if aging <= 0 {
+		aging = DefaultPriorityAging
+	}
+	return doc.UpdatedAt.Add(-time.Duration(doc.Priority) * aging)
+}
+
+// queueName is the name of the index for a queue,
+// the default queue uses the base name
+func queueName(base, queue string) string {
+	if queue == "" {
+		return base
+	}
+	return base + ":" + queue
+}
+
+// writeTask adds the writes saving the task and its indices to the
+// txn, so the task is never seen in a state different from the
+// indices. job is the job being submitted with the entry task.
+func (s *Strategy) writeTask(t *txn, doc *TaskDoc, stats *jobs.TaskStats, job *JobDoc) (err error) {
+	doc.Revision++
 	doc.UpdatedAt = time.Now()
-	if err = s.Store.Bucket(TasksBucket).Put(doc.ID, doc, jobs.Infinite); err != nil {
-		return
+	if doc.State == jobs.TaskCompleted && doc.CompletedAt.IsZero() {
+		doc.CompletedAt = doc.UpdatedAt
+	}
+	t.put(TasksBucket, doc.ID, doc, jobs.Infinite)
+	// a pending task scheduled in future stays in the time index
+	// until it's due, instead of the pending list
+	pending := doc.State == jobs.TaskPending
+	scheduled := pending && stats != nil && stats.ScheduledAt.After(doc.UpdatedAt)
+	scheduledIndex := queueName(ScheduledIndex, doc.Queue)
+	if scheduled {
+		t.setTime(scheduledIndex, doc.ID, stats.ScheduledAt)
+		queue := doc.Queue
+		t.onCommit(func() { s.notifyScheduled(queue) })
+	} else {
+		t.removeTime(scheduledIndex, doc.ID)
+	}
+	pendingQueue := queueName(PendingQueue, doc.Queue)
+	if s.FairShare {
+		pendingQueue = shareQueueName(doc.Queue, shareKey(doc))
+	}
+	if pending && !scheduled {
+		t.setTime(pendingQueue, doc.ID, s.queueTime(doc))
+		queue := doc.Queue
+		if s.FairShare {
+			key := shareKey(doc)
+			if err = s.activateShare(t, queue, key); err != nil {
+				return
+			}
+			// the share may be deactivated before committed
+			t.onCommit(func() { s.ensureShare(queue, key) })
+		}
+		// workers are woken up once the task is saved
+		t.onCommit(func() { s.notifyPending(queue) })
+	} else {
+		t.removeTime(pendingQueue, doc.ID)
+	}
+	// pending or running tasks with deadline are watched for expiration
+	if (pending || doc.State == jobs.TaskRunning) && !doc.Revert &&
+		stats != nil && !stats.ExpireAt.IsZero() {
+		t.setTime(ExpiringIndex, doc.ID, stats.ExpireAt)
+	} else {
+		t.removeTime(ExpiringIndex, doc.ID)
 	}
-	s.Store.OrderedList(PendingList).Set(doc.ID, doc.State == jobs.TaskPending)
-	s.Store.OrderedList(WaitingList).Set(doc.ID, doc.State == jobs.TaskWaiting)
+	t.setKey(WaitingList, doc.ID, doc.State == jobs.TaskWaiting)
+	t.setKey(RunningList, doc.ID, doc.State == jobs.TaskRunning)
+	t.setKey(StuckList, doc.ID, doc.State == jobs.TaskStucked)
 	if stats != nil {
-		err = s.Store.Bucket(TaskStatsBucket).Put(doc.ID, stats, jobs.Infinite)
+		t.put(TaskStatsBucket, doc.ID, stats, jobs.Infinite)
+	}
+	if doc.ParentID == "" {
+		err = s.updateJob(t, doc, job)
 	}
 	return
 }
@@ -231,15 +499,76 @@ func (s *Strategy) saveTask(doc *TaskDoc, stats *jobs.TaskStats) (err error) {
 type WorkerStrategy struct {
 	WorkerID string
 	Strategy *Strategy
+	Options  jobs.WorkerOptions
+
+	handles   map[string]*TaskHandle
+	nextQueue int
+	// deferredUntil is when the tasks deferred by rate limits may
+	// start, and rearmCh re-arms the timer of WatchTasks
+	deferredUntil time.Time
+	rearmCh       chan struct{}
+	lock          sync.Mutex
 }
 
 // FetchTask implements WorkerStrategy
 func (w *WorkerStrategy) FetchTask() (jobs.TaskHandle, error) {
-	e := w.Strategy.Store.OrderedList(PendingList).Enumerate(jobs.EnumOptions{PageSize: 10})
-	for {
+	handles, err := w.FetchTasks(1)
+	if len(handles) == 0 {
+		return nil, err
+	}
+	return handles[0], err
+}
+
+// FetchTasks implements WorkerStrategy
+func (w *WorkerStrategy) FetchTasks(max int) ([]jobs.TaskHandle, error) {
+	queues := w.Options.Queues
+	if len(queues) == 0 {
+		queues = []string{""}
+	}
+	// start from a different queue each time so all queues are served
+	w.lock.Lock()
+	start := w.nextQueue % len(queues)
+	w.nextQueue = start + 1
+	w.lock.Unlock()
+
+	var handles []jobs.TaskHandle
+	var err error
+	// the task names with all concurrency slots taken are skipped
+	// for the rest of the fetch
+	full := make(map[string]bool)
+	for i := range queues {
+		queue := queues[(start+i)%len(queues)]
+		if handles, err = w.fetchTasksFromQueue(queue, full, handles, max); len(handles) >= max || err != nil {
+			break
+		}
+	}
+	return handles, err
+}
+
+func (w *WorkerStrategy) fetchTasksFromQueue(queue string, full map[string]bool, handles []jobs.TaskHandle, max int) ([]jobs.TaskHandle, error) {
+	opts := jobs.EnumOptions{PageSize: 10}
+	store := w.Strategy.Store
+	// scheduled tasks which are due go first
+	scheduled := store.TimeIndex(queueName(ScheduledIndex, queue)).Due(time.Now(), opts)
+	handles, err := w.fetchTasksFrom(scheduled, full, handles, max)
+	if len(handles) >= max || err != nil {
+		return handles, err
+	}
+	if w.Strategy.FairShare {
+		return w.fetchTasksFromShares(queue, full, handles, max)
+	}
+	pending := store.TimeIndex(queueName(PendingQueue, queue)).Due(time.Now().Add(queueHorizon), opts)
+	return w.fetchTasksFrom(pending, full, handles, max)
+}
+
+// fetchTasksFrom takes the enumerated tasks, the names in full are
+// skipped without acquiring the tasks, and the names found with all
+// slots taken are added
+func (w *WorkerStrategy) fetchTasksFrom(e jobs.Enumerator, full map[string]bool, handles []jobs.TaskHandle, max int) ([]jobs.TaskHandle, error) {
+	for len(handles) < max {
 		tasks, err := e.Next()
 		if err != nil {
-			return nil, err
+			return handles, err
 		}
 		if tasks == nil {
 			break
@@ -249,24 +578,152 @@ func (w *WorkerStrategy) FetchTask() (jobs.TaskHandle, error) {
 			if err := val.Unmarshal(&id); err != nil || id == "" {
 				continue
 			}
-			acq, err := w.Strategy.Store.Acquire("task:"+id, w.WorkerID)
-			if err != nil || !acq.Acquired() {
+			if doc, err := w.Strategy.queryTaskDoc(id); err != nil || doc == nil || full[doc.Name] || !w.admits(doc) {
+				continue
+			}
+			handle, err := w.acquireTask(id)
+			if err != nil {
+				continue
+			}
+			if !isRunnable(handle.CachedTask) {
+				handle.Done()
 				continue
 			}
-			task, err := w.Strategy.QueryTask(id)
-			if err != nil || task == nil {
-				acq.Release()
+			// a task deferred by rate limits is notified when a token
+			// is refilled, and by slots when a slot is released
+			if !w.takeTokens(handle.CachedTask) {
+				handle.Done()
 				continue
 			}
-			return &TaskHandle{
-				WorkerStrategy: w,
-				TaskID:         id,
-				CachedTask:     task,
-				Acquisition:    acq,
-			}, nil
+			if ok, err := w.reserveSlot(handle); err != nil || !ok {
+				if err == nil {
+					full[handle.CachedTask.Name] = true
+				}
+				w.returnTokens(handle.CachedTask)
+				handle.Done()
+				continue
+			}
+			handles = append(handles, handle)
+			if len(handles) >= max {
+				break
+			}
+		}
+	}
+	return handles, nil
+}
+
+// admits filters the task before acquiring it
+func (w *WorkerStrategy) admits(doc *TaskDoc) bool {
+	if doc.State != jobs.TaskPending {
+		return false
+	}
+	return w.Options.Accept == nil || w.Options.Accept(doc.ToTask())
+}
+
+// takeTokens takes a token from each rate limit of the acquired task,
+// all or nothing: the tokens taken are returned if any bucket is empty
+func (w *WorkerStrategy) takeTokens(task *jobs.Task) bool {
+	if w.Options.RateLimits == nil {
+		return true
+	}
+	limits := w.Options.RateLimits(task)
+	taken := make(map[string]jobs.RateLimit, len(limits))
+	for name, limit := range limits {
+		ok, err := w.Strategy.Store.TokenBucket(name).Take(limit)
+		if err != nil || !ok {
+			w.returnTokenMap(taken)
+			if ok = err == nil && limit.Rate > 0; ok {
+				w.deferTask(time.Duration(float64(time.Second) / limit.Rate))
+			}
+			return false
 		}
+		taken[name] = limit
+	}
+	return true
+}
+
+// returnTokens returns the tokens taken for the task not started
+func (w *WorkerStrategy) returnTokens(task *jobs.Task) {
+	if w.Options.RateLimits != nil {
+		w.returnTokenMap(w.Options.RateLimits(task))
+	}
+}
+
+func (w *WorkerStrategy) returnTokenMap(limits map[string]jobs.RateLimit) {
+	for name, limit := range limits {
+		w.Strategy.Store.TokenBucket(name).Return(limit)
+	}
+}
+
+// isRunnable determines if the task is ready for execution,
+// the index may be stale as it's updated separately
+func isRunnable(task *jobs.Task) bool {
+	if task.State != jobs.TaskPending {
+		return false
 	}
-	return nil, nil
+	return task.Stats == nil || !task.Stats.ScheduledAt.After(time.Now())
+}
+
+// AcquireTask implements WorkerStrategy
+func (w *WorkerStrategy) AcquireTask(id string) (jobs.TaskHandle, error) {
+	return w.acquireTask(id)
+}
+
+// acquireTask acquires the ownership of the task
+func (w *WorkerStrategy) acquireTask(id string) (*TaskHandle, error) {
+	// acquisition is re-entrant for the same owner,
+	// don't hand out a task this worker is running
+	w.lock.Lock()
+	_, running := w.handles[id]
+	w.lock.Unlock()
+	if running {
+		return nil, jobs.ErrTaskBusy
+	}
+	acq, err := w.Strategy.Store.Acquire("task:"+id, w.WorkerID)
+	if err != nil {
+		return nil, err
+	}
+	if !acq.Acquired() {
+		return nil, jobs.ErrTaskBusy
+	}
+	if ttl := w.Strategy.LeaseTTL; ttl > 0 && ttl != acq.TTL() {
+		if err = acq.Refresh(ttl); err != nil {
+			acq.Release()
+			return nil, err
+		}
+	}
+	task, err := w.Strategy.QueryTask(id)
+	if err == nil && task == nil {
+		err = jobs.NotExist(id)
+	}
+	if err != nil {
+		acq.Release()
+		return nil, err
+	}
+	handle := &TaskHandle{
+		WorkerStrategy: w,
+		TaskID:         id,
+		CachedTask:     task,
+		Acquisition:    acq,
+	}
+	w.lock.Lock()
+	if w.handles == nil {
+		w.handles = make(map[string]*TaskHandle)
+	}
+	w.handles[id] = handle
+	w.lock.Unlock()
+	return handle, nil
+}
+
+// Heartbeat implements WorkerStrategy
+func (w *WorkerStrategy) Heartbeat(ttl time.Duration) error {
+	info := &jobs.WorkerInfo{ID: w.WorkerID, HeartbeatAt: time.Now()}
+	w.lock.Lock()
+	for id := range w.handles {
+		info.TaskIDs = append(info.TaskIDs, id)
+	}
+	w.lock.Unlock()
+	return w.Strategy.Store.Bucket(WorkersBucket).Put(w.WorkerID, info, ttl)
 }
 
 // TaskHandle implements jobs.TaskHandle
@@ -275,65 +732,188 @@ type TaskHandle struct {
 	TaskID         string
 	CachedTask     *jobs.Task
 	Acquisition    jobs.Acquisition
+
+	slot jobs.Acquisition
+	// leaseLost is also set when the handle is done, so a stage
+	// abandoned after timeout can't update the task
+	leaseLost bool
+	// leaseLock also guards CachedTask, as an abandoned stage may
+	// still access it
+	leaseLock sync.Mutex
 }
 
 // Task implements TaskHandle
 func (h *TaskHandle) Task() *jobs.Task {
+	h.leaseLock.Lock()
+	defer h.leaseLock.Unlock()
 	return h.CachedTask
 }
 
-// SubmitTask implements TaskHandle
+// SubmitTask implements TaskHandle, it's idempotent: if the sub task
+// already exists, task is replaced by the existing one
 func (h *TaskHandle) SubmitTask(task *jobs.Task) (err error) {
-	if err = h.refreshTask(); err != nil {
+	parent, parentVal, err := h.refreshTask()
+	if err != nil {
 		return
 	}
-	doc := NewTaskDoc(h.CachedTask)
-	doc.SubTaskIDs = append(doc.SubTaskIDs, task.ID)
-	if err = h.WorkerStrategy.Strategy.saveTask(doc, nil); err == nil {
-		doc = NewTaskDoc(task)
+	s := h.WorkerStrategy.Strategy
+	existing, err := s.queryTaskDoc(task.ID)
+	if err != nil {
+		return
+	}
+	if existing != nil && existing.ParentID != task.ParentID {
+		return jobs.ErrTaskIDConflict
+	}
+	// the parent and the sub task are saved all-or-nothing
+	t := s.newTxn()
+	if !hasID(parent.SubTaskIDs, task.ID) {
+		doc := NewTaskDoc(parent)
+		doc.SubTaskIDs = append(doc.SubTaskIDs, task.ID)
+		t.expect(TasksBucket, doc.ID, parentVal)
+		if err = s.writeTask(t, doc, parent.Stats, nil); err != nil {
+			return
+		}
+	}
+	if existing == nil {
+		doc := NewTaskDoc(task)
 		doc.State = jobs.TaskPending
-		err = h.WorkerStrategy.Strategy.saveTask(doc, nil)
+		t.expect(TasksBucket, doc.ID, nil)
+		if err = s.writeTask(t, doc, task.Stats, nil); err != nil {
+			return
+		}
+	}
+	if err = t.commit(); err == nil && existing != nil {
+		var found *jobs.Task
+		if found, err = s.QueryTask(task.ID); err == nil {
+			*task = *found
+		}
 	}
 	if err == nil {
-		err = h.refreshTask()
+		_, _, err = h.refreshTask()
 	}
 	return
 }
 
+func hasID(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
 // Update implements TaskHandle
 func (h *TaskHandle) Update(task *jobs.Task) (err error) {
-	if err = h.refreshTask(); err != nil {
+	current, currentVal, err := h.refreshTask()
+	if err != nil {
 		return
 	}
-	doc := NewTaskDoc(h.CachedTask)
+	// the fields of task are copied over the stored doc, so
+	// task must be read from the current revision
+	if current == nil || task.Revision != current.Revision {
+		return jobs.ErrConflict
+	}
+	doc := NewTaskDoc(current)
 	doc.Stage = task.Stage
 	doc.ResumeTo = task.ResumeTo
 	doc.State = task.State
 	doc.Result = task.Result
+	doc.Revert = task.Revert
+	doc.Retries = task.Retries
+	doc.RevertRetries = task.RevertRetries
+	doc.Saga = task.Saga
 	doc.Data = json.RawMessage(task.Data)
 	doc.Output = json.RawMessage(task.Output)
 	doc.Errors = task.Errors
 	doc.SubTaskIDs = task.SubTaskIDs
-	if err = h.WorkerStrategy.Strategy.saveTask(doc, task.Stats); err == nil {
-		err = h.refreshTask()
+	stats := task.Stats
+	if doc.State == jobs.TaskRunning {
+		// record the worker for detecting lost workers
+		stats = &jobs.TaskStats{}
+		if task.Stats != nil {
+			*stats = *task.Stats
+		}
+		stats.WorkerID = h.WorkerStrategy.WorkerID
+	}
+	s := h.WorkerStrategy.Strategy
+	t := s.newTxn()
+	t.expect(TasksBucket, doc.ID, currentVal)
+	if err = s.writeTask(t, doc, stats, nil); err != nil {
+		return
+	}
+	if err = t.commit(); err == nil {
+		_, _, err = h.refreshTask()
 	}
 	return
 }
 
 // Done implements TaskHandle
 func (h *TaskHandle) Done() error {
+	h.leaseLock.Lock()
+	h.leaseLost = true
+	h.leaseLock.Unlock()
+	w := h.WorkerStrategy
+	w.lock.Lock()
+	if w.handles[h.TaskID] == h {
+		delete(w.handles, h.TaskID)
+	}
+	w.lock.Unlock()
+	if h.slot != nil {
+		h.slot.Release()
+		// the tasks deferred by the slot can start now
+		w.Strategy.notifyPending(h.Task().Queue)
+	}
 	return h.Acquisition.Release()
 }
 
-func (h *TaskHandle) refreshTask() error {
-	err := h.Acquisition.Refresh(h.Acquisition.TTL())
+// LeaseTTL implements TaskHandle
+func (h *TaskHandle) LeaseTTL() time.Duration {
+	h.leaseLock.Lock()
+	defer h.leaseLock.Unlock()
+	return h.Acquisition.TTL()
+}
+
+// Renew implements TaskHandle
+func (h *TaskHandle) Renew() error {
+	h.leaseLock.Lock()
+	defer h.leaseLock.Unlock()
+	return h.renewLease()
+}
+
+// renewLease refreshes the acquisition, leaseLock must be held
+func (h *TaskHandle) renewLease() error {
+	if h.leaseLost {
+		return jobs.ErrTaskLeaseLost
+	}
+	if err := h.Acquisition.Refresh(h.Acquisition.TTL()); err != nil {
+		h.leaseLost = true
+		return jobs.ErrTaskLeaseLost
+	}
+	if h.slot != nil {
+		if err := h.slot.Refresh(h.slot.TTL()); err != nil {
+			h.leaseLost = true
+			return jobs.ErrTaskLeaseLost
+		}
+	}
+	return nil
+}
+
+// refreshTask renews the lease and reloads the task, the task and
+// the stored value are returned for the caller to save against
+func (h *TaskHandle) refreshTask() (*jobs.Task, jobs.Value, error) {
+	h.leaseLock.Lock()
+	err := h.renewLease()
+	h.leaseLock.Unlock()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	task, err := h.WorkerStrategy.Strategy.QueryTask(h.TaskID)
+	task, val, err := h.WorkerStrategy.Strategy.queryTask(h.TaskID)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	h.leaseLock.Lock()
 	h.CachedTask = task
-	return nil
+	h.leaseLock.Unlock()
+	return task, val, nil
 }
@@ -0,0 +1,321 @@
+package simple
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+	"github.com/evo-cloud/cloudrt/jobs/stores/memory"
+)
+
+func newTestDispatcher() (*Strategy, *jobs.Dispatcher) {
+	s := &Strategy{Store: memory.NewStore()}
+	return s, jobs.NewDispatcher(s)
+}
+
+func buildTask(t *testing.T, b *jobs.TaskBuilder) *jobs.Task {
+	task, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestQueryJobNotExist(t *testing.T) {
+	s, d := newTestDispatcher()
+	job, err := s.QueryJob("nope")
+	if err != nil || job != nil {
+		t.Fatalf("expect no job, got %+v, %v", job, err)
+	}
+	if _, err = d.Job("nope"); !jobs.IsNotExist(err) {
+		t.Fatalf("expect not exist, got %v", err)
+	}
+}
+
+func TestUpdateStaleTask(t *testing.T) {
+	s, d := newTestDispatcher()
+	if _, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t").SetID("root"))).Submit(); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := s.NewWorker("w", jobs.WorkerOptions{}).AcquireTask("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Done()
+	stale := *handle.Task()
+	task := *handle.Task()
+	task.Stage = "first"
+	if err = handle.Update(&task); err != nil {
+		t.Fatal(err)
+	}
+	stale.Stage = "second"
+	if err = handle.Update(&stale); err != jobs.ErrConflict {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+	if task := handle.Task(); task.Stage != "first" {
+		t.Fatalf("stale update saved: %+v", task)
+	}
+}
+
+func TestUpdateAfterDone(t *testing.T) {
+	s, d := newTestDispatcher()
+	if _, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t").SetID("root"))).Submit(); err != nil {
+		t.Fatal(err)
+	}
+	w := s.NewWorker("w", jobs.WorkerOptions{})
+	handle, err := w.AcquireTask("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle.Done()
+	// the same worker owns the task again
+	current, err := w.AcquireTask("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer current.Done()
+	task := *current.Task()
+	if err = handle.Update(&task); err != jobs.ErrTaskLeaseLost {
+		t.Fatalf("expect lease lost, got %v", err)
+	}
+	if err = handle.Renew(); err != jobs.ErrTaskLeaseLost {
+		t.Fatalf("expect lease lost, got %v", err)
+	}
+	if err = current.Update(&task); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTakeTokensAllOrNothing(t *testing.T) {
+	s, _ := newTestDispatcher()
+	limit := jobs.RateLimit{Rate: 0.01, Burst: 1}
+	if ok, _ := s.Store.TokenBucket("empty").Take(limit); !ok {
+		t.Fatal("token not taken")
+	}
+	w := s.NewWorker("w", jobs.WorkerOptions{
+		RateLimits: func(*jobs.Task) map[string]jobs.RateLimit {
+			return map[string]jobs.RateLimit{"full": limit, "empty": limit}
+		},
+	}).(*WorkerStrategy)
+	if w.takeTokens(&jobs.Task{}) {
+		t.Fatal("expect tokens not taken")
+	}
+	if ok, _ := s.Store.TokenBucket("full").Take(limit); !ok {
+		t.Fatal("token not returned")
+	}
+}
+
+func TestHouseKeepLeaseOwner(t *testing.T) {
+	s, d := newTestDispatcher()
+	if _, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t").SetID("root"))).Submit(); err != nil {
+		t.Fatal(err)
+	}
+	// the worker and the watcher are named after the same host
+	handle, err := s.NewWorker("host", jobs.WorkerOptions{}).AcquireTask("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Done()
+	task := *handle.Task()
+	task.State = jobs.TaskRunning
+	if err = handle.Update(&task); err != nil {
+		t.Fatal(err)
+	}
+	visited := false
+	err = s.HouseKeep("host", func(ctx jobs.HouseKeepContext) error {
+		visited = true
+		if _, err := ctx.Acquire("root"); err != jobs.ErrTaskBusy {
+			t.Fatalf("expect busy, got %v", err)
+		}
+		return nil
+	})
+	if err != nil || !visited {
+		t.Fatalf("house keeping not run: %v", err)
+	}
+	if err = handle.Renew(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fetchFirst submits a low priority task, and a high priority one
+// after a while, then fetches a task
+func fetchFirst(t *testing.T, aging time.Duration) string {
+	s, d := newTestDispatcher()
+	s.PriorityAging = aging
+	if _, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t").SetID("low"))).Submit(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := d.NewJob().SetPriority(1).SetTask(buildTask(t, jobs.NewTask("t").SetID("high"))).Submit(); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := s.NewWorker("w", jobs.WorkerOptions{}).FetchTask()
+	if err != nil || handle == nil {
+		t.Fatalf("no task fetched: %v", err)
+	}
+	defer handle.Done()
+	return handle.Task().ID
+}
+
+func TestPriorityAging(t *testing.T) {
+	if id := fetchFirst(t, time.Second); id != "high" {
+		t.Fatalf("expect high priority task first, got %s", id)
+	}
+	// the low priority task waited longer than the aging
+	if id := fetchFirst(t, 10*time.Millisecond); id != "low" {
+		t.Fatalf("expect aged task first, got %s", id)
+	}
+}
+
+func TestDrainPendingList(t *testing.T) {
+	s, d := newTestDispatcher()
+	if _, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t").SetID("root"))).Submit(); err != nil {
+		t.Fatal(err)
+	}
+	// the task is left pending by an earlier version
+	if err := s.Store.TimeIndex(PendingQueue).Remove("root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store.OrderedList(PendingList).Set("root", true); err != nil {
+		t.Fatal(err)
+	}
+	w := s.NewWorker("w", jobs.WorkerOptions{})
+	if handle, err := w.FetchTask(); err != nil || handle != nil {
+		t.Fatalf("expect no task, got %v, %v", handle, err)
+	}
+	if err := s.HouseKeep("h", func(jobs.HouseKeepContext) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if queued, _ := s.Store.OrderedList(PendingList).Has("root"); queued {
+		t.Fatal("pending list not drained")
+	}
+	handle, err := w.FetchTask()
+	if err != nil || handle == nil || handle.Task().ID != "root" {
+		t.Fatalf("expect task root, got %v, %v", handle, err)
+	}
+	handle.Done()
+}
+
+// fetchIDs fetches the tasks and releases them
+func fetchIDs(t *testing.T, w jobs.WorkerStrategy) []string {
+	handles, err := w.FetchTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, handle := range handles {
+		ids = append(ids, handle.Task().ID)
+		handle.Done()
+	}
+	return ids
+}
+
+func TestFetchFromQueues(t *testing.T) {
+	s, d := newTestDispatcher()
+	for _, b := range []*jobs.TaskBuilder{
+		jobs.NewTask("render").SetID("g").SetQueue("gpu"),
+		jobs.NewTask("other").SetID("o"),
+		jobs.NewTask("plain").SetID("p"),
+	} {
+		if _, err := d.NewJob().SetTask(buildTask(t, b)).Submit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gpu := s.NewWorker("gpu", jobs.WorkerOptions{Queues: []string{"gpu"}})
+	if ids := fetchIDs(t, gpu); len(ids) != 1 || ids[0] != "g" {
+		t.Fatalf("expect task g, got %v", ids)
+	}
+	// the worker only accepts the tasks it knows
+	plain := s.NewWorker("plain", jobs.WorkerOptions{
+		Accept: func(task *jobs.Task) bool { return task.Name == "plain" },
+	})
+	if ids := fetchIDs(t, plain); len(ids) != 1 || ids[0] != "p" {
+		t.Fatalf("expect task p, got %v", ids)
+	}
+	all := s.NewWorker("all", jobs.WorkerOptions{Queues: []string{"", "gpu"}})
+	if ids := fetchIDs(t, all); len(ids) != 3 {
+		t.Fatalf("expect all tasks, got %v", ids)
+	}
+}
+
+func TestFetchSkipsFullSlots(t *testing.T) {
+	s, d := newTestDispatcher()
+	for _, name := range []string{"charge", "charge", "charge", "other"} {
+		if _, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask(name))).Submit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reserves := 0
+	opts := jobs.WorkerOptions{
+		MaxConcurrency: func(name string) int {
+			reserves++
+			if name == "charge" {
+				return 1
+			}
+			return 0
+		},
+	}
+	handles, err := s.NewWorker("w1", opts).FetchTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, handle := range handles {
+			handle.Done()
+		}
+	}()
+	if len(handles) != 2 || handles[0].Task().Name == handles[1].Task().Name {
+		t.Fatalf("expect one task of each name, got %d", len(handles))
+	}
+	// once the slots are found taken, the other tasks of the name are
+	// skipped without being acquired
+	reserves = 0
+	if more, err := s.NewWorker("w2", opts).FetchTasks(10); err != nil || len(more) != 0 {
+		t.Fatalf("expect no tasks, got %d, %v", len(more), err)
+	}
+	if reserves != 1 {
+		t.Fatalf("expect one reservation, got %d", reserves)
+	}
+}
+
+func TestFairShareInterleaving(t *testing.T) {
+	s, d := newTestDispatcher()
+	s.FairShare = true
+	for _, tenant := range []string{"a", "a", "a", "a", "a", "b", "b"} {
+		if _, err := d.NewJob().SetTenant(tenant).SetTask(buildTask(t, jobs.NewTask("t"))).Submit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := s.NewWorker("w", jobs.WorkerOptions{})
+	var handles []jobs.TaskHandle
+	defer func() {
+		for _, handle := range handles {
+			handle.Done()
+		}
+	}()
+	var tenants []string
+	for i := 0; i < 4; i++ {
+		handle, err := w.FetchTask()
+		if err != nil || handle == nil {
+			t.Fatalf("no task fetched: %v", err)
+		}
+		handles = append(handles, handle)
+		tenants = append(tenants, handle.Task().Tenant)
+	}
+	// the tenant submitted later isn't starved by the earlier one
+	for i := 1; i < len(tenants); i++ {
+		if tenants[i] == tenants[i-1] {
+			t.Fatalf("expect tenants interleaved, got %v", tenants)
+		}
+	}
+	rest, err := w.FetchTasks(10)
+	handles = append(handles, rest...)
+	if err != nil || len(rest) != 3 {
+		t.Fatalf("expect the other 3 tasks, got %d, %v", len(rest), err)
+	}
+	for _, handle := range rest {
+		if tenant := handle.Task().Tenant; tenant != "a" {
+			t.Fatalf("unexpected tenant %s", tenant)
+		}
+	}
+}
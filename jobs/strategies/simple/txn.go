@@ -0,0 +1,116 @@
+package simple
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+)
+
+// txn collects the writes of a state transition. If the store is
+// transactional, they are committed all-or-nothing with the
+// expectations checked atomically. Otherwise the expectations are
+// checked first, and the writes are applied one by one, stopping at
+// the first error.
+type txn struct {
+	strategy *Strategy
+	batch    jobs.Batch
+	hooks    []func()
+	err      error
+}
+
+func (s *Strategy) newTxn() *txn {
+	t := &txn{strategy: s}
+	if store, ok := s.Store.(jobs.Transactional); ok {
+		t.batch = store.Batch()
+	}
+	return t
+}
+
+func (t *txn) store() jobs.Store {
+	return t.strategy.Store
+}
+
+// expect fails the commit with jobs.ErrConflict unless the value is
+// not changed since read, nil expects the key not exist
+func (t *txn) expect(bucket, key string, val jobs.Value) {
+	if t.batch != nil {
+		t.batch.Expect(bucket, key, val)
+		return
+	}
+	if t.err != nil {
+		return
+	}
+	current, err := t.store().Bucket(bucket).Get(key)
+	if err != nil {
+		t.err = err
+	} else if !sameValue(current, val) {
+		t.err = jobs.ErrConflict
+	}
+}
+
+func (t *txn) put(bucket, key string, value interface{}, ttl time.Duration) {
+	if t.batch != nil {
+		t.batch.Put(bucket, key, value, ttl)
+	} else if t.err == nil {
+		t.err = t.store().Bucket(bucket).Put(key, value, ttl)
+	}
+}
+
+func (t *txn) setKey(list, id string, exist bool) {
+	if t.batch != nil {
+		t.batch.SetKey(list, id, exist)
+	} else if t.err == nil {
+		t.err = t.store().OrderedList(list).Set(id, exist)
+	}
+}
+
+func (t *txn) setTime(index, id string, at time.Time) {
+	if t.batch != nil {
+		t.batch.SetTime(index, id, at)
+	} else if t.err == nil {
+		t.err = t.store().TimeIndex(index).Set(id, at)
+	}
+}
+
+func (t *txn) removeTime(index, id string) {
+	if t.batch != nil {
+		t.batch.RemoveTime(index, id)
+	} else if t.err == nil {
+		t.err = t.store().TimeIndex(index).Remove(id)
+	}
+}
+
+// onCommit runs fn after committed
+func (t *txn) onCommit(fn func()) {
+	t.hooks = append(t.hooks, fn)
+}
+
+func (t *txn) commit() error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.batch != nil {
+		if err := t.batch.Commit(); err != nil {
+			return err
+		}
+	}
+	for _, fn := range t.hooks {
+		fn()
+	}
+	return nil
+}
+
+// sameValue compares the encoded values, as values from different
+// reads are not comparable otherwise
+func sameValue(a, b jobs.Value) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var encodedA, encodedB json.RawMessage
+	if a.Unmarshal(&encodedA) != nil || b.Unmarshal(&encodedB) != nil {
+		return false
+	}
+	return bytes.Equal(encodedA, encodedB)
+}
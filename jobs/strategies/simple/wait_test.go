@@ -0,0 +1,77 @@
+package simple
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/evo-cloud/cloudrt/jobs"
+	"github.com/evo-cloud/cloudrt/jobs/stores/memory"
+)
+
+func TestWaitJobNotExist(t *testing.T) {
+	_, d := newTestDispatcher()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := d.WaitJob(ctx, "nope"); !jobs.IsNotExist(err) {
+		t.Fatalf("expect not exist, got %v", err)
+	}
+	errCh := make(chan error, 1)
+	d.OnJobDone("nope", func(job jobs.Job, err error) { errCh <- err })
+	select {
+	case err := <-errCh:
+		if !jobs.IsNotExist(err) {
+			t.Fatalf("expect not exist, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback not invoked")
+	}
+	ch := make(chan jobs.Job, 1)
+	sub := d.SubscribeJob("nope", ch)
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("subscription not finished")
+	}
+	if len(ch) != 0 {
+		t.Fatal("unexpected job delivered")
+	}
+}
+
+// countingStrategy counts the queries loading the task tree of a job
+type countingStrategy struct {
+	*Strategy
+	queries int32
+}
+
+func (s *countingStrategy) QueryJob(id string) (*jobs.Job, error) {
+	atomic.AddInt32(&s.queries, 1)
+	return s.Strategy.QueryJob(id)
+}
+
+func TestWaitJobPollsState(t *testing.T) {
+	s := &countingStrategy{Strategy: &Strategy{Store: memory.NewStore()}}
+	d := jobs.NewDispatcher(s)
+	d.JobPollInterval = 10 * time.Millisecond
+	d.NewTaskExec("t").Entry(func(ctx jobs.Context) error {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}).Commit()
+	d.Worker("w")
+	d.Start()
+	defer d.Stop()
+
+	job, err := d.NewJob().SetTask(buildTask(t, jobs.NewTask("t"))).Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := waitJob(t, d, job.ID)
+	if done.State != jobs.JobSucceeded || done.Progress == nil || done.Progress.Succeeded != 1 {
+		t.Fatalf("unexpected job %+v", done)
+	}
+	if n := atomic.LoadInt32(&s.queries); n != 1 {
+		t.Fatalf("job loaded %d times", n)
+	}
+}
@@ -1,27 +1,88 @@
 package jobs
 
+import "time"
+
 // Strategy is the contract for scheduling strategy
 type Strategy interface {
 	SubmitJob(*Job) error
 	CancelJob(id string) error
 	IsJobCanceling(id string) (bool, error)
 	QueryJob(id string) (*Job, error)
+	// QueryJobState queries the state of the job without the task
+	// tree, a NotExistError is returned if the job doesn't exist
+	QueryJobState(id string) (JobState, error)
 	QueryTask(id string) (*Task, error)
-	NewWorker(id string) WorkerStrategy
+	// QueryStuckTasks lists the stucked tasks
+	QueryStuckTasks() ([]*Task, error)
+	NewWorker(id string, opts WorkerOptions) WorkerStrategy
 	HouseKeep(id string, logic HouseKeepLogic) error
 }
 
+// WorkerOptions specifies the tasks a worker fetches
+type WorkerOptions struct {
+	// Queues are the queues served, the default queue is "",
+	// and it's the only one served if Queues is empty
+	Queues []string
+	// Accept filters the tasks before acquiring, all are accepted if nil
+	Accept func(*Task) bool
+	// MaxConcurrency is the max number of running tasks of the name
+	// across all workers, 0 for unlimited
+	MaxConcurrency func(name string) int
+	// RateLimits are the rate limits applying to the task, keyed by
+	// the name of the token bucket in the store
+	RateLimits func(*Task) map[string]RateLimit
+}
+
 // WorkerStrategy is strategy instance per worker
 type WorkerStrategy interface {
 	FetchTask() (TaskHandle, error)
+	// FetchTasks fetches up to max tasks in a batch
+	FetchTasks(max int) ([]TaskHandle, error)
+	// Heartbeat publishes the worker is alive for ttl
+	Heartbeat(ttl time.Duration) error
+	// AcquireTask acquires a specific task regardless of its state
+	AcquireTask(id string) (TaskHandle, error)
+}
+
+// TaskWatcher is optionally implemented by WorkerStrategy, the worker
+// is woken up when tasks become pending instead of polling
+type TaskWatcher interface {
+	// WatchTasks notifies when tasks may become pending until stopCh
+	// is closed, nil if not supported. The returned chan is closed
+	// when the watch ends.
+	WatchTasks(stopCh StopChan) (<-chan struct{}, error)
+}
+
+// WorkerInfo is the runtime information published by a worker
+type WorkerInfo struct {
+	ID          string    `json:"id"`           // worker id
+	TaskIDs     []string  `json:"task-ids"`     // tasks being executed
+	HeartbeatAt time.Time `json:"heartbeat-at"` // last heartbeat time
+}
+
+// HasTask determines if the worker is executing the task
+func (w *WorkerInfo) HasTask(id string) bool {
+	for _, taskID := range w.TaskIDs {
+		if taskID == id {
+			return true
+		}
+	}
+	return false
 }
 
 // TaskHandle is the handle of a running task owned by a worker
 type TaskHandle interface {
 	Task() *Task
 	SubmitTask(*Task) error
+	// Update saves the task, it fails with ErrConflict if the task
+	// is modified since the revision the task was read at
 	Update(*Task) error
 	Done() error
+	// LeaseTTL is the TTL of the ownership
+	LeaseTTL() time.Duration
+	// Renew extends the ownership, once failed, the task is
+	// no longer owned and Update is rejected
+	Renew() error
 }
 
 // HouseKeepContext provides context for HouseKeepLogic
@@ -29,6 +90,8 @@ type HouseKeepContext interface {
 	Job() *Job
 	Task() *Task
 	Acquire(taskID string) (TaskHandle, error)
+	// Worker queries a live worker, nil if the worker is lost
+	Worker(id string) (*WorkerInfo, error)
 	Stop()
 }
 
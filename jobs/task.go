@@ -29,6 +29,24 @@ const (
 	TaskAborted
 )
 
+// Compensation declares how a task is compensated when the sibling
+// tasks fail (saga)
+type Compensation int
+
+// Compensation kinds
+const (
+	Compensable Compensation = iota // rolled back when a sibling fails
+	Pivot                           // commits the siblings, never rolled back
+)
+
+// SagaOutcome records the compensation of sub tasks on the parent
+type SagaOutcome struct {
+	Compensated []string `json:"compensated"` // sub tasks rolled back, in order
+	Aborted     []string `json:"aborted"`     // sub tasks never started
+	Kept        []string `json:"kept"`        // sub tasks committed by the pivot
+	Pivot       string   `json:"pivot"`       // the pivot stopped compensation
+}
+
 // TaskStats contains the runtime information
 type TaskStats struct {
 	WorkerID    string    `json:"worker-id"`    // assign to a worker
@@ -38,25 +56,36 @@ type TaskStats struct {
 
 // Task defines the details of a task`
 type Task struct {
-	ID         string      `json:"id"`          // globally unique task id
-	ParentID   string      `json:"parent-id"`   // parent task id
-	JobID      string      `json:"job-id"`      // job id
-	Name       string      `json:"name"`        // task name
-	Params     []byte      `json:"params"`      // encoded parameters
-	State      TaskState   `json:"state"`       // current state
-	Result     TaskResult  `json:"result"`      // result when task completes
-	Revert     bool        `json:"revert"`      // in rollback direction
-	Retries    uint        `json:"retries"`     // current retry number
-	MaxRetries uint        `json:"max-retries"` // max count of retries
-	Stage      string      `json:"stage"`       // current stage
-	ResumeTo   string      `json:"resume-to"`   // next stage resume to
-	Data       []byte      `json:"data"`        // task specific data
-	Output     []byte      `json:"output"`      // output when completed
-	Errors     []TaskError `json:"errors"`      // errors happened
-	CreatedAt  time.Time   `json:"created-at"`  // task creation time
-	UpdatedAt  time.Time   `json:"updated-at"`  // last modification time
-	SubTaskIDs []string    `json:"subtask-ids"` // subtask ID list
-	Stats      *TaskStats  `json:"stats"`       // runtime stats
+	ID            string       `json:"id"`             // globally unique task id
+	ParentID      string       `json:"parent-id"`      // parent task id
+	JobID         string       `json:"job-id"`         // job id
+	Name          string       `json:"name"`           // task name
+	Priority      int          `json:"priority"`       // higher goes first
+	Queue         string       `json:"queue"`          // served by workers of the queue
+	JobRateLimit  *RateLimit   `json:"job-rate-limit"` // rate limit of the tasks in the job
+	Tenant        string       `json:"tenant"`         // shares the workers with the tenant's jobs
+	Params        []byte       `json:"params"`         // encoded parameters
+	State         TaskState    `json:"state"`          // current state
+	Result        TaskResult   `json:"result"`         // result when task completes
+	Revert        bool         `json:"revert"`         // in rollback direction
+	Compensation  Compensation `json:"compensation"`   // compensable or pivot
+	Saga          *SagaOutcome `json:"saga,omitempty"` // compensation of sub tasks
+	Retries       uint         `json:"retries"`        // current retry number
+	MaxRetries    uint         `json:"max-retries"`    // max count of retries
+	RevertRetries uint         `json:"revert-retries"` // retry number in rollback
+	RetryPolicy   *RetryPolicy `json:"retry-policy"`   // overrides TaskExec's policy
+	RevertPolicy  *RetryPolicy `json:"revert-policy"`  // retry policy of rollback
+	Stage         string       `json:"stage"`          // current stage
+	ResumeTo      string       `json:"resume-to"`      // next stage resume to
+	Data          []byte       `json:"data"`           // task specific data
+	Output        []byte       `json:"output"`         // output when completed
+	Errors        []TaskError  `json:"errors"`         // errors happened
+	CreatedAt     time.Time    `json:"created-at"`     // task creation time
+	UpdatedAt     time.Time    `json:"updated-at"`     // last modification time
+	CompletedAt   time.Time    `json:"completed-at"`   // first completion time
+	Revision      uint64       `json:"revision"`       // incremented on each save
+	SubTaskIDs    []string     `json:"subtask-ids"`    // subtask ID list
+	Stats         *TaskStats   `json:"stats"`          // runtime stats
 }
 
 // GetParams extracts the parameters
@@ -78,13 +107,13 @@ func (t *Task) GetData(d interface{}) error {
 }
 
 // SetData encodes and saves the data
-func (t *Task) SetData(d interface{}) *Task {
+func (t *Task) SetData(d interface{}) error {
 	encoded, err := json.Marshal(d)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	t.Data = encoded
-	return t
+	return nil
 }
 
 // GetOutput decodes the output
@@ -97,13 +126,19 @@ func (t *Task) GetOutput(p interface{}) error {
 }
 
 // SetOutput encodes and saves the output
-func (t *Task) SetOutput(p interface{}) *Task {
+func (t *Task) SetOutput(p interface{}) error {
 	encoded, err := json.Marshal(p)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	t.Output = encoded
-	return t
+	return nil
+}
+
+// Started determines if any stage of the task has been executed
+func (t *Task) Started() bool {
+	return t.State > TaskPending || t.Revert || t.Stage != "" || t.ResumeTo != "" ||
+		t.Retries > 0 || len(t.Errors) > 0 || len(t.SubTaskIDs) > 0
 }
 
 // NewError constructs a TaskError
@@ -118,13 +153,27 @@ type TaskSubmitter interface {
 
 // TaskBuilder is a helper to build a task
 type TaskBuilder struct {
-	Submitter TaskSubmitter
-	ID        string
-	Name      string
-	Params    interface{}
+	Submitter    TaskSubmitter
+	IDGenerator  IDGenerator
+	ID           string
+	ParentID     string
+	Stage        string
+	Key          string
+	Name         string
+	Priority     int
+	Queue        string
+	JobRateLimit *RateLimit
+	Tenant       string
+	Params       interface{}
+	ScheduledAt  time.Time
+	ExpireAt     time.Time
+	RetryPolicy  *RetryPolicy
+	RevertPolicy *RetryPolicy
+	Compensation Compensation
 }
 
-// NewTask starts defining a task
+// NewTask starts defining a task, the ID is generated by
+// DefaultIDGenerator if not specified
 func NewTask(name string) *TaskBuilder {
 	return &TaskBuilder{Name: name}
 }
@@ -135,31 +184,118 @@ func (b *TaskBuilder) SetID(id string) *TaskBuilder {
 	return b
 }
 
+// SetKey derives the ID from the parent task, the stage and the key
+// if ID is not specified, so re-executing the stage submits the same
+// sub task again instead of creating a duplicate
+func (b *TaskBuilder) SetKey(key string) *TaskBuilder {
+	b.Key = key
+	return b
+}
+
+// Pivot declares the task a pivot: once completed, it's not rolled back
+// and the sibling tasks completed before it are not compensated
+func (b *TaskBuilder) Pivot() *TaskBuilder {
+	b.Compensation = Pivot
+	return b
+}
+
+// SetPriority specifies the priority, higher goes first.
+// A sub task inherits the priority of the parent by default.
+func (b *TaskBuilder) SetPriority(priority int) *TaskBuilder {
+	b.Priority = priority
+	return b
+}
+
+// SetQueue specifies the queue, the task only runs on workers serving
+// the queue. The queue of TaskExec is used if not specified.
+func (b *TaskBuilder) SetQueue(queue string) *TaskBuilder {
+	b.Queue = queue
+	return b
+}
+
 // With specifies the parameters which will be encoded later
 func (b *TaskBuilder) With(params interface{}) *TaskBuilder {
 	b.Params = params
 	return b
 }
 
-// Build builds the task
-func (b *TaskBuilder) Build() *Task {
-	task := &Task{ID: b.ID}
+// RunAt specifies the time when the task is executed
+func (b *TaskBuilder) RunAt(t time.Time) *TaskBuilder {
+	b.ScheduledAt = t
+	return b
+}
+
+// Delay specifies the task is executed after a duration
+func (b *TaskBuilder) Delay(d time.Duration) *TaskBuilder {
+	return b.RunAt(time.Now().Add(d))
+}
+
+// Deadline specifies the time when the task expires if not completed
+func (b *TaskBuilder) Deadline(t time.Time) *TaskBuilder {
+	b.ExpireAt = t
+	return b
+}
+
+// Timeout specifies the task expires if not completed after a duration
+func (b *TaskBuilder) Timeout(d time.Duration) *TaskBuilder {
+	return b.Deadline(time.Now().Add(d))
+}
+
+// Retry specifies the retry policy of the task
+func (b *TaskBuilder) Retry(policy *RetryPolicy) *TaskBuilder {
+	b.RetryPolicy = policy
+	return b
+}
+
+// RevertRetry specifies the retry policy of rollback, the task is
+// stucked once the revert retries are exhausted
+func (b *TaskBuilder) RevertRetry(policy *RetryPolicy) *TaskBuilder {
+	b.RevertPolicy = policy
+	return b
+}
+
+// Build builds the task, it fails if the parameters can't be encoded
+func (b *TaskBuilder) Build() (*Task, error) {
+	task := &Task{
+		ID:           b.ID,
+		Name:         b.Name,
+		Priority:     b.Priority,
+		Queue:        b.Queue,
+		JobRateLimit: b.JobRateLimit,
+		Tenant:       b.Tenant,
+		Compensation: b.Compensation,
+	}
 	if task.ID == "" {
-		// TODO generate a unique ID
+		if b.Key != "" && b.ParentID != "" {
+			task.ID = DeriveID(b.ParentID, b.Stage, b.Key)
+		} else {
+			task.ID = newID(b.IDGenerator)
+		}
+	}
+	if b.RetryPolicy != nil {
+		task.RetryPolicy = b.RetryPolicy
+		task.MaxRetries = b.RetryPolicy.MaxRetries
+	}
+	task.RevertPolicy = b.RevertPolicy
+	if !b.ScheduledAt.IsZero() || !b.ExpireAt.IsZero() {
+		task.Stats = &TaskStats{ScheduledAt: b.ScheduledAt, ExpireAt: b.ExpireAt}
 	}
 	if b.Params != nil {
 		encoded, err := json.Marshal(b.Params)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		task.Params = encoded
 	}
-	return task
+	return task, nil
 }
 
 // Submit submits the task for execution
 func (b *TaskBuilder) Submit() (*Task, error) {
-	task := b.Build()
+	task, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
 	return task, b.Submitter.SubmitTask(task)
 }
 
@@ -168,8 +304,9 @@ type TaskFn func(Context) error
 
 // Stage defines a named stage with specified task function
 type Stage struct {
-	Name string // name of the stage
-	Fn   TaskFn // task function
+	Name    string        // name of the stage
+	Fn      TaskFn        // task function
+	Timeout time.Duration // max execution time, 0 for unlimited
 }
 
 // EntryStage is the name of entry stage (the first one)
@@ -177,8 +314,13 @@ const EntryStage = "entry"
 
 // TaskExec is the implemetation of the task
 type TaskExec struct {
-	Name   string  // name of the task
-	Stages []Stage // stages in the task
+	Name           string       // name of the task
+	Stages         []Stage      // stages in the task
+	RetryPolicy    *RetryPolicy // default retry policy of the tasks
+	RevertPolicy   *RetryPolicy // default retry policy of rollback
+	Queue          string       // default queue of the tasks
+	MaxConcurrency int          // max running tasks across all workers, 0 for unlimited
+	RateLimit      *RateLimit   // max starts per second across all workers
 }
 
 // TaskExecBuilder builds a TaskExec
@@ -201,6 +343,48 @@ func (b *TaskExecBuilder) Entry(fn TaskFn) *TaskExecBuilder {
 	return b
 }
 
+// StageTimeout specifies the max execution time of a stage
+func (b *TaskExecBuilder) StageTimeout(name string, timeout time.Duration) *TaskExecBuilder {
+	for i := range b.Executor.Stages {
+		if b.Executor.Stages[i].Name == name {
+			b.Executor.Stages[i].Timeout = timeout
+		}
+	}
+	return b
+}
+
+// Retry specifies the default retry policy of the tasks
+func (b *TaskExecBuilder) Retry(policy *RetryPolicy) *TaskExecBuilder {
+	b.Executor.RetryPolicy = policy
+	return b
+}
+
+// RevertRetry specifies the default retry policy of rollback
+func (b *TaskExecBuilder) RevertRetry(policy *RetryPolicy) *TaskExecBuilder {
+	b.Executor.RevertPolicy = policy
+	return b
+}
+
+// MaxConcurrency limits the number of the tasks running at the same
+// time across all workers
+func (b *TaskExecBuilder) MaxConcurrency(n int) *TaskExecBuilder {
+	b.Executor.MaxConcurrency = n
+	return b
+}
+
+// LimitRate limits the starts per second of the tasks across
+// all workers, with burst as the max starts at once
+func (b *TaskExecBuilder) LimitRate(rate float64, burst int) *TaskExecBuilder {
+	b.Executor.RateLimit = &RateLimit{Rate: rate, Burst: burst}
+	return b
+}
+
+// Queue specifies the default queue of the tasks
+func (b *TaskExecBuilder) Queue(queue string) *TaskExecBuilder {
+	b.Executor.Queue = queue
+	return b
+}
+
 // Commit adds TaskExec to dispatcher
 func (b *TaskExecBuilder) Commit() *Dispatcher {
 	if b.committed {
@@ -0,0 +1,16 @@
+package jobs
+
+import "testing"
+
+func TestEncodingErrors(t *testing.T) {
+	if _, err := NewTask("t").With(make(chan int)).Build(); err == nil {
+		t.Fatal("expect Build fails")
+	}
+	task := &Task{}
+	if err := task.SetData(make(chan int)); err == nil {
+		t.Fatal("expect SetData fails")
+	}
+	if err := task.SetOutput(make(chan int)); err == nil {
+		t.Fatal("expect SetOutput fails")
+	}
+}
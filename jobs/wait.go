@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// WaitJob blocks until the job reaches a final state and returns it.
+// The job state is polled from the strategy, so the job can be
+// executed by workers in other processes. Only the state is polled,
+// the job with progress is loaded once it's final. A NotExistError is
+// returned if the job doesn't exist.
+func (d *Dispatcher) WaitJob(ctx context.Context, id string) (Job, error) {
+	interval := d.JobPollInterval
+	if interval <= 0 {
+		interval = JobPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		state, err := d.Strategy.QueryJobState(id)
+		if err != nil {
+			return Job{}, err
+		}
+		if state.IsFinal() {
+			return d.Job(id)
+		}
+		select {
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// JobCallback is invoked when the subscribed job reaches a final
+// state, or waiting fails with err
+type JobCallback func(job Job, err error)
+
+// JobSubscription waits for a job in background
+type JobSubscription struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	doneCh chan struct{}
+}
+
+// OnJobDone calls fn when the job reaches a final state.
+// fn is not called if the subscription is canceled.
+func (d *Dispatcher) OnJobDone(id string, fn JobCallback) *JobSubscription {
+	return d.subscribeJob(id, func(s *JobSubscription, job Job, err error) {
+		fn(job, err)
+	})
+}
+
+// SubscribeJob delivers the job to ch when it reaches a final state.
+// Nothing is delivered if waiting fails, e.g. the job doesn't exist,
+// or the subscription is canceled.
+func (d *Dispatcher) SubscribeJob(id string, ch chan<- Job) *JobSubscription {
+	return d.subscribeJob(id, func(s *JobSubscription, job Job, err error) {
+		if err == nil {
+			select {
+			case ch <- job:
+			case <-s.ctx.Done():
+			}
+		}
+	})
+}
+
+func (d *Dispatcher) subscribeJob(id string, fn func(*JobSubscription, Job, error)) *JobSubscription {
+	s := &JobSubscription{doneCh: make(chan struct{})}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go func() {
+		defer close(s.doneCh)
+		job, err := d.WaitJob(s.ctx, id)
+		if s.ctx.Err() == nil {
+			fn(s, job, err)
+		}
+	}()
+	return s
+}
+
+// Cancel stops waiting
+func (s *JobSubscription) Cancel() {
+	s.cancel()
+}
+
+// Done returns a chan which is closed when the subscription finishes
+func (s *JobSubscription) Done() <-chan struct{} {
+	return s.doneCh
+}
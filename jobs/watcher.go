@@ -1,6 +1,9 @@
 package jobs
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
 type localWatcher struct {
 	id         string
@@ -10,7 +13,16 @@ type localWatcher struct {
 func (w *localWatcher) Run(stopCh StopChan) {
 	for {
 		w.dispatcher.Strategy.HouseKeep(w.id, func(ctx HouseKeepContext) error {
-			err := w.wakeupWaitingTasks(ctx)
+			err := w.abortCanceledTask(ctx)
+			if err == nil {
+				err = w.wakeupWaitingTasks(ctx)
+			}
+			if err == nil {
+				err = w.recoverOrphanedTask(ctx)
+			}
+			if err == nil {
+				err = w.expireTask(ctx)
+			}
 			if err != nil {
 				// TODO logging
 			}
@@ -25,55 +37,281 @@ func (w *localWatcher) Run(stopCh StopChan) {
 	}
 }
 
+// abortCanceledTask cancels the pending task of a canceled job without
+// waiting for a worker to fetch it, e.g. it's scheduled in future or in
+// a queue no worker serves. The task not started completes as aborted,
+// otherwise the rollback starts now.
+func (w *localWatcher) abortCanceledTask(ctx HouseKeepContext) error {
+	task := ctx.Task()
+	if task.State != TaskPending || task.Revert || !w.dispatcher.isJobCanceling(task.JobID) {
+		return nil
+	}
+	return w.updateTask(ctx, task.ID, func(task *Task) bool {
+		if task.State != TaskPending || task.Revert {
+			return false
+		}
+		cancelTask(task)
+		return true
+	})
+}
+
 func (w *localWatcher) wakeupWaitingTasks(ctx HouseKeepContext) error {
 	task := ctx.Task()
 	if task.State != TaskWaiting {
 		return nil
 	}
+	if !task.Revert && w.dispatcher.isJobCanceling(task.JobID) {
+		return w.updateTask(ctx, task.ID, func(task *Task) bool {
+			if task.State != TaskWaiting || task.Revert {
+				return false
+			}
+			cancelTask(task)
+			return true
+		})
+	}
 
-	completes := 0
-	failures := 0
-	aborted := 0
+	subTasks := make([]*Task, 0, len(task.SubTaskIDs))
 	for _, taskID := range task.SubTaskIDs {
 		subTask, err := w.dispatcher.Strategy.QueryTask(taskID)
 		if err != nil {
 			// TODO log
 			return err
 		}
-		if subTask.State == TaskCompleted {
-			completes++
-			switch subTask.Result {
-			case TaskFailure:
-				failures++
-			case TaskAborted:
-				aborted++
+		if subTask == nil {
+			return NotExist(taskID)
+		}
+		subTasks = append(subTasks, subTask)
+	}
+	if task.Revert {
+		return w.compensate(ctx, subTasks)
+	}
+
+	completes := 0
+	for _, subTask := range subTasks {
+		if subTask.State != TaskCompleted {
+			continue
+		}
+		completes++
+		if subTask.Result != TaskSuccess {
+			// the task fails with the sub task, and the completed
+			// sub tasks are compensated
+			return w.updateTask(ctx, task.ID, func(task *Task) bool {
+				if task.State != TaskWaiting || task.Revert {
+					return false
+				}
+				task.Result = TaskFailure
+				task.Errors = append(task.Errors, *task.NewError(TaskErrFail).
+					SetMessage("sub task failed: " + subTask.ID))
+				startRevert(task)
+				return true
+			})
+		}
+	}
+
+	if completes < len(subTasks) {
+		return nil
+	}
+	return w.updateTask(ctx, task.ID, func(task *Task) bool {
+		if task.State != TaskWaiting || task.Revert {
+			return false
+		}
+		if task.ResumeTo != "" {
+			task.State = TaskPending
+		} else {
+			task.State = TaskCompleted
+			task.Result = TaskSuccess
+		}
+		return true
+	})
+}
+
+// compensate reverts the completed sub tasks of a reverting task one by
+// one in reverse completion order, and the task rolls back itself after
+// that. Compensation stops at a completed pivot, the sub tasks completed
+// before it are kept, and the task is stucked.
+func (w *localWatcher) compensate(ctx HouseKeepContext, subTasks []*Task) error {
+	busy := false
+	var candidates []*Task
+	for _, subTask := range subTasks {
+		if !subTask.Started() {
+			// nothing to compensate
+			busy = true
+			if err := w.updateTask(ctx, subTask.ID, func(task *Task) bool {
+				if task.Started() {
+					return false
+				}
+				cancelTask(task)
+				return true
+			}); err != nil {
+				return err
 			}
+		} else if needsRevert(subTask) {
+			candidates = append(candidates, subTask)
+		} else if subTask.State != TaskCompleted {
+			// running, reverting or stucked
+			busy = true
+		}
+	}
+	if busy {
+		return nil
+	}
+
+	sort.Sort(byCompletedAtDesc(candidates))
+	if len(candidates) > 0 && candidates[0].Compensation != Pivot {
+		return w.updateTask(ctx, candidates[0].ID, func(task *Task) bool {
+			if !needsRevert(task) {
+				return false
+			}
+			startRevert(task)
+			return true
+		})
+	}
+
+	taskID := ctx.Task().ID
+	return w.updateTask(ctx, taskID, func(task *Task) bool {
+		if task.State != TaskWaiting || !task.Revert {
+			return false
+		}
+		task.Saga = sagaOutcome(subTasks, candidates)
+		if len(candidates) > 0 {
+			task.State = TaskStucked
+			task.Result = TaskFailure
+			task.Errors = append(task.Errors, *task.NewError(TaskErrStuck).
+				SetMessage("compensation stopped at pivot: " + task.Saga.Pivot))
+		} else {
+			task.State = TaskPending
+		}
+		return true
+	})
+}
+
+// needsRevert determines if a sub task should be compensated
+// when the parent is reverting
+func needsRevert(task *Task) bool {
+	return task.State == TaskCompleted && !task.Revert && task.Result == TaskSuccess
+}
+
+// sagaOutcome summarizes the compensation, kept are the sub tasks not
+// compensated, starting from the pivot
+func sagaOutcome(subTasks, kept []*Task) *SagaOutcome {
+	outcome := &SagaOutcome{}
+	var compensated []*Task
+	for _, subTask := range subTasks {
+		if subTask.Revert {
+			compensated = append(compensated, subTask)
+		} else if subTask.Result == TaskAborted {
+			outcome.Aborted = append(outcome.Aborted, subTask.ID)
 		}
 	}
+	sort.Sort(byUpdatedAt(compensated))
+	for _, subTask := range compensated {
+		outcome.Compensated = append(outcome.Compensated, subTask.ID)
+	}
+	for _, subTask := range kept {
+		outcome.Kept = append(outcome.Kept, subTask.ID)
+	}
+	if len(kept) > 0 {
+		outcome.Pivot = kept[0].ID
+	}
+	return outcome
+}
+
+type byCompletedAtDesc []*Task
+
+func (s byCompletedAtDesc) Len() int           { return len(s) }
+func (s byCompletedAtDesc) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCompletedAtDesc) Less(i, j int) bool { return s[i].CompletedAt.After(s[j].CompletedAt) }
+
+type byUpdatedAt []*Task
 
-	if completes >= len(task.SubTaskIDs) {
-		handle, err := ctx.Acquire(task.ID)
+func (s byUpdatedAt) Len() int           { return len(s) }
+func (s byUpdatedAt) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byUpdatedAt) Less(i, j int) bool { return s[i].UpdatedAt.Before(s[j].UpdatedAt) }
+
+// updateTask acquires the task and saves it if fn returns true,
+// the task is skipped if it's owned by others
+func (w *localWatcher) updateTask(ctx HouseKeepContext, id string, fn func(*Task) bool) error {
+	handle, err := ctx.Acquire(id)
+	if err == ErrTaskBusy {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer handle.Done()
+	task := handle.Task()
+	if !fn(task) {
+		return nil
+	}
+	return w.dispatcher.saveTask(handle, task)
+}
+
+// recoverOrphanedTask reschedules a running task whose worker is lost.
+// The worker is lost if it no longer reports the task in heartbeat,
+// and the task lease lapsed so it can be acquired.
+func (w *localWatcher) recoverOrphanedTask(ctx HouseKeepContext) error {
+	task := ctx.Task()
+	if task.State != TaskRunning {
+		return nil
+	}
+	var workerID string
+	if task.Stats != nil {
+		workerID = task.Stats.WorkerID
+	}
+	if workerID != "" {
+		worker, err := ctx.Worker(workerID)
 		if err != nil {
 			return err
 		}
-		defer handle.Done()
-		task = handle.Task()
-		if completes >= len(task.SubTaskIDs) &&
-			task.State == TaskWaiting {
-			if task.ResumeTo != "" {
-				task.State = TaskPending
-			} else {
-				task.State = TaskCompleted
-				if failures > 0 {
-					task.Result = TaskFailure
-				} else if aborted > 0 {
-					task.Result = TaskAborted
-				} else {
-					task.Result = TaskSuccess
-				}
-			}
-			handle.Update(task)
+		if worker != nil && worker.HasTask(task.ID) {
+			return nil
 		}
 	}
-	return nil
+
+	handle, err := ctx.Acquire(task.ID)
+	if err == ErrTaskBusy {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer handle.Done()
+	task = handle.Task()
+	if task.State != TaskRunning {
+		return nil
+	}
+	// recovery counts against MaxRetries like a retry
+	setErrorState(task, task.NewError(TaskErrRetry).
+		SetMessage("worker lost: "+workerID),
+		w.dispatcher.retryPolicy(task))
+	return w.dispatcher.saveTask(handle, task)
+}
+
+// expireTask fails the task which is pending or running past its
+// deadline. A running task owned by a live worker is left to the worker.
+func (w *localWatcher) expireTask(ctx HouseKeepContext) error {
+	if !isExpired(ctx.Task()) {
+		return nil
+	}
+	handle, err := ctx.Acquire(ctx.Task().ID)
+	if err == ErrTaskBusy {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer handle.Done()
+	task := handle.Task()
+	if !isExpired(task) {
+		return nil
+	}
+	setErrorState(task, newTimeoutError(*task), w.dispatcher.retryPolicy(task))
+	return w.dispatcher.saveTask(handle, task)
+}
+
+func isExpired(task *Task) bool {
+	if task.State != TaskPending && task.State != TaskRunning {
+		return false
+	}
+	if task.Revert || task.Stats == nil || task.Stats.ExpireAt.IsZero() {
+		return false
+	}
+	return !time.Now().Before(task.Stats.ExpireAt)
 }
@@ -2,22 +2,69 @@ package jobs
 
 import (
 	"fmt"
+	"runtime/debug"
+	"sync"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 const (
 	fetchInterval = 500 * time.Millisecond
+	// polling with notifications only covers the missed ones, e.g.
+	// the slots of crashed workers freed on expiration
+	notifiedFetchInterval = 5 * time.Second
+	// time given to a stage to return after its deadline, or after
+	// interrupted by the drain timeout
+	stopGracePeriod = time.Second
 )
 
 type localWorker struct {
-	dispatcher *Dispatcher
-	strategy   WorkerStrategy
+	dispatcher  *Dispatcher
+	strategy    WorkerStrategy
+	concurrency int
 }
 
 type localContext struct {
-	worker *localWorker
-	handle TaskHandle
-	stopCh StopChan
+	worker  *localWorker
+	handle  TaskHandle
+	stopCh  StopChan
+	stopper *taskStopper
+	drainCh StopChan
+	ctx     context.Context
+}
+
+// taskStopper stops a running task once, and tells if the task is
+// interrupted by the shutdown of the worker rather than failed
+type taskStopper struct {
+	stopCh   chan struct{}
+	cancel   context.CancelFunc
+	once     sync.Once
+	shutdown bool
+}
+
+func (s *taskStopper) stop(shutdown bool) {
+	s.once.Do(func() {
+		s.shutdown = shutdown
+		close(s.stopCh)
+		s.cancel()
+	})
+}
+
+// interrupted determines if the task is stopped by shutdown
+func (s *taskStopper) interrupted() bool {
+	select {
+	case <-s.stopCh:
+		return s.shutdown
+	default:
+		return false
+	}
+}
+
+// stop stops the task for the deadline, the job cancellation or the
+// lease lost
+func (l *localContext) stop() {
+	l.stopper.stop(false)
 }
 
 func (l *localContext) dispatcher() *Dispatcher {
@@ -33,32 +80,150 @@ func (l *localContext) strategy() Strategy {
 }
 
 func (w *localWorker) Run(stopCh StopChan) {
+	heartbeatStopCh := make(chan struct{})
+	defer close(heartbeatStopCh)
+	go w.heartbeat(heartbeatStopCh)
+
+	// slots limits the number of in-flight tasks, and doneCh
+	// triggers fetching when a task completes
+	slots := make(chan struct{}, w.concurrency)
+	doneCh := make(chan struct{}, w.concurrency)
+	var wg sync.WaitGroup
+	drainCh := make(chan struct{})
+	defer w.drain(&wg, drainCh)
+
+	watchStopCh := make(chan struct{})
+	defer close(watchStopCh)
+	var notifyCh <-chan struct{}
+
 	for {
-		timeCh := time.After(fetchInterval)
-		handle, err := w.strategy.FetchTask()
-		if err == nil && handle != nil {
-			w.runTaskByHandle(handle, stopCh)
-			handle.Done()
+		if notifyCh == nil {
+			notifyCh = w.watchTasks(watchStopCh)
+		}
+		interval := fetchInterval
+		if notifyCh != nil {
+			interval = notifiedFetchInterval
+		}
+		timeCh := time.After(interval)
+		if available := cap(slots) - len(slots); available > 0 {
+			handles, err := w.strategy.FetchTasks(available)
+			if err != nil {
+				// TODO logging
+			}
+			for _, handle := range handles {
+				slots <- struct{}{}
+				wg.Add(1)
+				go func(handle TaskHandle) {
+					defer func() {
+						<-slots
+						select {
+						case doneCh <- struct{}{}:
+						default:
+						}
+						wg.Done()
+					}()
+					w.runTaskByHandle(handle, drainCh)
+					handle.Done()
+				}(handle)
+			}
 		}
 		select {
 		case <-timeCh:
+		case <-doneCh:
+		case _, ok := <-notifyCh:
+			if !ok {
+				// watch again in next round
+				notifyCh = nil
+			}
 		case <-stopCh:
 			return
 		}
 	}
 }
 
-func (w *localWorker) runTaskByHandle(handle TaskHandle, stopCh StopChan) {
+// drain waits for the in-flight tasks after the worker stops fetching,
+// drainCh is closed to interrupt them once DrainTimeout passes
+func (w *localWorker) drain(wg *sync.WaitGroup, drainCh chan struct{}) {
+	if timeout := w.dispatcher.DrainTimeout; timeout > 0 {
+		timer := time.AfterFunc(timeout, func() { close(drainCh) })
+		defer timer.Stop()
+	}
+	wg.Wait()
+}
+
+// watchTasks subscribes to task notifications if supported by the
+// strategy, nil falls back to polling
+func (w *localWorker) watchTasks(stopCh StopChan) <-chan struct{} {
+	watcher, ok := w.strategy.(TaskWatcher)
+	if !ok {
+		return nil
+	}
+	notifyCh, err := watcher.WatchTasks(stopCh)
+	if err != nil {
+		// TODO logging
+		return nil
+	}
+	return notifyCh
+}
+
+func (w *localWorker) heartbeat(stopCh StopChan) {
+	for {
+		if err := w.strategy.Heartbeat(w.dispatcher.HeartbeatTimeout); err != nil {
+			// TODO logging
+		}
+		select {
+		case <-time.After(w.dispatcher.HeartbeatInterval):
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// runTaskByHandle runs the task until it completes, or it's interrupted
+// when drainCh is closed
+func (w *localWorker) runTaskByHandle(handle TaskHandle, drainCh StopChan) {
+	task := handle.Task()
+	if !task.Revert && w.dispatcher.isJobCanceling(task.JobID) {
+		cancelTask(task)
+		if err := w.dispatcher.saveTask(handle, task); err != nil {
+			// TODO logging
+		}
+		return
+	}
+	goCtx := context.WithValue(context.Background(), jobIDKey, task.JobID)
+	goCtx = context.WithValue(goCtx, taskIDKey, task.ID)
+	goCtx, cancel := context.WithCancel(goCtx)
+
+	stopper := &taskStopper{stopCh: make(chan struct{}), cancel: cancel}
+	defer stopper.stop(false)
+	go func() {
+		select {
+		case <-drainCh:
+			stopper.stop(true)
+		case <-stopper.stopCh:
+		}
+	}()
+
 	ctx := Context{
 		local: &localContext{
-			worker: w,
-			handle: handle,
-			stopCh: stopCh,
+			worker:  w,
+			handle:  handle,
+			stopCh:  stopper.stopCh,
+			stopper: stopper,
+			drainCh: drainCh,
+			ctx:     goCtx,
 		},
 	}
 
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go w.monitorTask(ctx, task.JobID, !task.Revert, doneCh)
+
 	err := w.runTask(ctx)
-	if err != nil {
+	if stopper.interrupted() {
+		// whatever the stage returns, it may not have finished
+		err = w.handBack(ctx)
+	} else if err != nil {
 		taskErr, ok := err.(*TaskError)
 		if !ok {
 			taskErr = ctx.Fail(err)
@@ -72,6 +237,31 @@ func (w *localWorker) runTaskByHandle(handle TaskHandle, stopCh StopChan) {
 	}
 }
 
+// monitorTask keeps the task owned until doneCh is closed,
+// the task is stopped once the lease is lost, or the job is canceled
+// if cancelable (rollback is never canceled)
+func (w *localWorker) monitorTask(ctx Context, jobID string, cancelable bool, doneCh StopChan) {
+	handle := ctx.local.taskHandle()
+	interval := handle.LeaseTTL() / 3
+	if interval <= 0 {
+		return
+	}
+	for {
+		select {
+		case <-time.After(interval):
+		case <-doneCh:
+			return
+		}
+		if err := handle.Renew(); err != nil {
+			ctx.local.stop()
+			return
+		}
+		if cancelable && w.dispatcher.isJobCanceling(jobID) {
+			ctx.local.stop()
+		}
+	}
+}
+
 func (w *localWorker) runTask(ctx Context) error {
 	task := ctx.Task()
 	stage := w.dispatcher.findStage(task.Name, task.ResumeTo)
@@ -90,7 +280,85 @@ func (w *localWorker) runTask(ctx Context) error {
 		return nil
 	}
 
-	return stage.Fn(ctx)
+	deadline := stageDeadline(&task, stage)
+	if !deadline.IsZero() {
+		goCtx, cancel := context.WithDeadline(ctx.local.ctx, deadline)
+		defer cancel()
+		ctx.local.ctx = goCtx
+	}
+	return w.runStageUntil(ctx, stage.Fn, deadline)
+}
+
+// callStage runs the task function and converts a panic to TaskError
+func (w *localWorker) callStage(ctx Context, fn TaskFn) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			task := ctx.Task()
+			err = task.NewError(w.dispatcher.PanicPolicy.ErrorType()).
+				SetMessage(fmt.Sprintf("panic: %v", r)).
+				SetOutput(debug.Stack())
+		}
+	}()
+	return fn(ctx)
+}
+
+// stageDeadline is the earlier one of task deadline and stage timeout,
+// task deadline doesn't apply to rollback
+func stageDeadline(task *Task, stage *Stage) (deadline time.Time) {
+	if !task.Revert && task.Stats != nil {
+		deadline = task.Stats.ExpireAt
+	}
+	if stage.Timeout > 0 {
+		stageDeadline := time.Now().Add(stage.Timeout)
+		if deadline.IsZero() || stageDeadline.Before(deadline) {
+			deadline = stageDeadline
+		}
+	}
+	return
+}
+
+// runStageUntil runs the stage and closes StopCh when deadline passes,
+// or when the worker is draining and interrupts the task. The stage is
+// abandoned if it doesn't return in stopGracePeriod, the task handle
+// rejects its updates once the task is done.
+func (w *localWorker) runStageUntil(ctx Context, fn TaskFn, deadline time.Time) error {
+	var timerCh <-chan time.Time
+	if !deadline.IsZero() {
+		timeout := deadline.Sub(time.Now())
+		if timeout <= 0 {
+			return newTimeoutError(ctx.Task())
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timerCh = timer.C
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- w.callStage(ctx, fn)
+	}()
+	var err error
+	select {
+	case err = <-errCh:
+		return err
+	case <-timerCh:
+		ctx.local.stop()
+		err = newTimeoutError(ctx.Task())
+	case <-ctx.local.drainCh:
+		// the task is handed back by the caller
+		ctx.local.stopper.stop(true)
+		err = context.Canceled
+	}
+	select {
+	case <-errCh:
+	case <-time.After(stopGracePeriod):
+	}
+	return err
+}
+
+func newTimeoutError(task Task) *TaskError {
+	return task.NewError(TaskErrTimeout).
+		SetMessage("deadline exceeded").
+		CausedBy(ErrTaskExpired)
 }
 
 func setFailureState(task *Task, cause error) {
@@ -100,15 +368,61 @@ func setFailureState(task *Task, cause error) {
 		}
 		// else keep state as Completed
 	} else {
-		task.Revert = true
+		startRevert(task)
+	}
+}
+
+// startRevert switches the task to rollback direction. The sub tasks
+// are reverted first, and the task waits for them before its own
+// rollback.
+func startRevert(task *Task) {
+	task.Revert = true
+	task.ResumeTo = ""
+	if len(task.SubTaskIDs) > 0 {
+		task.State = TaskWaiting
+	} else {
 		task.State = TaskPending
-		// TODO revert retries
 	}
+	if task.Stats != nil && !task.Stats.ScheduledAt.IsZero() {
+		stats := *task.Stats
+		stats.ScheduledAt = time.Time{}
+		task.Stats = &stats
+	}
+}
+
+// cancelTask aborts a task of a canceled job: the task completes
+// if not started, otherwise it's reverted
+func cancelTask(task *Task) {
+	task.Result = TaskAborted
+	if task.Started() {
+		startRevert(task)
+	} else {
+		task.State = TaskCompleted
+	}
+}
+
+// handBack returns the task interrupted by shutdown to pending, so the
+// stage is re-run by another worker. It's not a failure, so no error is
+// recorded and no retry is counted.
+func (w *localWorker) handBack(ctx Context) error {
+	task := ctx.Task()
+	if task.State != TaskRunning {
+		return nil
+	}
+	task.State = TaskPending
+	task.Result = TaskUnknown
+	task.ResumeTo = task.Stage
+	return ctx.local.handle.Update(&task)
 }
 
 func (w *localWorker) taskComplete(ctx Context, taskErr *TaskError) error {
 	task := ctx.Task()
-	if len(task.SubTaskIDs) > 0 {
+	if !task.Revert && w.dispatcher.isJobCanceling(task.JobID) {
+		cancelTask(&task)
+		return w.dispatcher.saveTask(ctx.local.handle, &task)
+	}
+	// sub tasks are already reverted before rollback
+	if len(task.SubTaskIDs) > 0 && !task.Revert {
 		task.State = TaskWaiting
 	} else if task.ResumeTo != "" {
 		task.State = TaskPending
@@ -125,25 +439,58 @@ func (w *localWorker) taskComplete(ctx Context, taskErr *TaskError) error {
 			task.Result = TaskSuccess
 		}
 	} else {
-		task.Result = TaskFailure
-		task.Errors = append(task.Errors, *taskErr)
-		switch taskErr.Type {
-		case TaskErrFail:
-			setFailureState(&task, taskErr.Cause)
-		case TaskErrRetry:
-			// TODO revert retries
-			if task.Retries >= task.MaxRetries {
-				setFailureState(&task, taskErr.Cause)
-			} else {
-				task.State = TaskPending
-				task.Retries++
-				if task.ResumeTo == "" {
-					task.ResumeTo = task.Stage
-				}
-			}
-		case TaskErrStuck:
-			task.State = TaskStucked
+		setErrorState(&task, taskErr, w.dispatcher.retryPolicy(&task))
+	}
+	return w.dispatcher.saveTask(ctx.local.handle, &task)
+}
+
+func setErrorState(task *Task, taskErr *TaskError, policy *RetryPolicy) {
+	task.Result = TaskFailure
+	task.Errors = append(task.Errors, *taskErr)
+	switch taskErr.Type {
+	case TaskErrFail, TaskErrTimeout:
+		if task.Revert && taskErr.Cause != ErrTaskNonRevertable {
+			// rollback has nowhere to fall back, retry it
+			// until the revert retries are exhausted
+			retryOrFail(task, taskErr.Cause, policy)
+		} else {
+			setFailureState(task, taskErr.Cause)
 		}
+	case TaskErrRetry:
+		retryOrFail(task, taskErr.Cause, policy)
+	case TaskErrStuck:
+		task.State = TaskStucked
 	}
-	return ctx.local.handle.Update(&task)
+}
+
+// retryOrFail retries the current stage if the retries in the current
+// direction are not exhausted, policy is the one of current direction
+func retryOrFail(task *Task, cause error, policy *RetryPolicy) {
+	retries := &task.Retries
+	if task.Revert {
+		retries = &task.RevertRetries
+	}
+	if *retries >= policy.MaxRetries {
+		setFailureState(task, cause)
+		return
+	}
+	task.State = TaskPending
+	*retries++
+	if task.ResumeTo == "" {
+		task.ResumeTo = task.Stage
+	}
+	scheduleAfter(task, policy.Backoff(*retries))
+}
+
+// scheduleAfter delays the next execution of the task
+func scheduleAfter(task *Task, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	stats := TaskStats{}
+	if task.Stats != nil {
+		stats = *task.Stats
+	}
+	stats.ScheduledAt = time.Now().Add(delay)
+	task.Stats = &stats
 }